@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type CodeEventType string
+
+const (
+	CodeIssued   CodeEventType = "issued"
+	CodeRedeemed CodeEventType = "redeemed"
+	CodeExpired  CodeEventType = "expired"
+	// CodeReplayed is a redeemed code being presented again
+	CodeReplayed CodeEventType = "replayed"
+)
+
+// CodeEvent is something that happened to an authorization code in the AuthorizationCodeStore
+type CodeEvent struct {
+	Type CodeEventType
+	Time time.Time
+	// Code is a copy of the code when the event happened
+	Code AuthorizationCode
+}
+
+// Subscription receives events on C until it's closed. Events are never waited on, when C's
+// buffer is full the event is dropped and counted in Dropped
+type Subscription struct {
+	C <-chan CodeEvent
+
+	ch      chan CodeEvent
+	dropped atomic.Uint64
+	broker  *eventBroker
+}
+
+// Dropped is the number of events this subscriber missed because it wasn't keeping up
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close unsubscribes and closes C
+func (s *Subscription) Close() {
+	s.broker.unsubscribe(s)
+}
+
+// eventBroker fans events out to every subscription without blocking the publisher
+type eventBroker struct {
+	mu     *sync.RWMutex
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{
+		mu:   &sync.RWMutex{},
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+func (b *eventBroker) subscribe(buffer int) *Subscription {
+	ch := make(chan CodeEvent, buffer)
+	s := &Subscription{C: ch, ch: ch, broker: b}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(ch)
+		return s
+	}
+	b.subs[s] = struct{}{}
+	return s
+}
+
+func (b *eventBroker) unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.ch)
+	}
+}
+
+// publish holds the read lock while sending so a subscription can't be closed mid send,
+// the sends never block so that is never long
+func (b *eventBroker) publish(eventType CodeEventType, code AuthorizationCode) {
+	e := CodeEvent{Type: eventType, Time: time.Now(), Code: code}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for s := range b.subs {
+		select {
+		case s.ch <- e:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// close closes every subscription, later subscriptions are closed straight away
+func (b *eventBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for s := range b.subs {
+		close(s.ch)
+	}
+	b.subs = make(map[*Subscription]struct{})
+}
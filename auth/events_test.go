@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func nextEvent(t *testing.T, sub *Subscription) CodeEvent {
+	select {
+	case e := <-sub.C:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("no event was published")
+		return CodeEvent{}
+	}
+}
+
+func TestAuthorizationCodeStore_Subscribe(t *testing.T) {
+	acs := NewAuthCodeStore()
+	first := acs.Subscribe(10)
+	second := acs.Subscribe(10)
+	ac := NewAuthorizationCode("verifier", "plain", "")
+	ac.ClientId = "client"
+
+	acs.Add(ac)
+	_, _ = acs.Redeem(ac.Code, "verifier", "client", "")
+	_, _ = acs.Redeem(ac.Code, "verifier", "client", "")
+
+	for _, sub := range []*Subscription{first, second} {
+		for _, expected := range []CodeEventType{CodeIssued, CodeRedeemed, CodeReplayed} {
+			e := nextEvent(t, sub)
+			assert.Equal(t, expected, e.Type)
+			assert.Equal(t, HashToken(ac.Code), e.Code.CodeHash)
+			assert.Empty(t, e.Code.Code)
+		}
+		assert.Zero(t, sub.Dropped())
+	}
+}
+
+func TestAuthorizationCodeStore_Subscribe_Expired(t *testing.T) {
+	acs := NewAuthCodeStore()
+	sub := acs.Subscribe(10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go acs.StartExpiration(ctx)
+
+	ac := NewAuthorizationCode("", "plain", "")
+	ac.Exp = time.Now().Unix()
+	acs.Add(ac)
+
+	assert.Equal(t, CodeIssued, nextEvent(t, sub).Type)
+	e := nextEvent(t, sub)
+	assert.Equal(t, CodeExpired, e.Type)
+	assert.Equal(t, HashToken(ac.Code), e.Code.CodeHash)
+}
+
+func TestAuthorizationCodeStore_Subscribe_DropsWhenFull(t *testing.T) {
+	acs := NewAuthCodeStore()
+	slow := acs.Subscribe(1)
+	fast := acs.Subscribe(10)
+
+	for i := 0; i < 3; i++ {
+		acs.Add(NewAuthorizationCode("", "plain", ""))
+	}
+
+	// the slow subscriber missing events doesn't affect the others
+	assert.Equal(t, uint64(2), slow.Dropped())
+	assert.Zero(t, fast.Dropped())
+	assert.Len(t, fast.C, 3)
+}
+
+func TestSubscription_Close(t *testing.T) {
+	acs := NewAuthCodeStore()
+	sub := acs.Subscribe(10)
+
+	sub.Close()
+	sub.Close()
+	acs.Add(NewAuthorizationCode("", "plain", ""))
+
+	_, open := <-sub.C
+	assert.False(t, open)
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+)
+
+// TokenHashKey is the server secret codes and refresh tokens are hashed with before they are stored.
+// A random one is used until main loads the persisted key, hashes made with a different key won't match
+var TokenHashKey = mustGenerateTokenHashKey()
+
+const tokenHashKeySize = 32
+
+func mustGenerateTokenHashKey() []byte {
+	key := make([]byte, tokenHashKeySize)
+	if _, err := rand.Read(key); err != nil {
+		panic("failed to generate token hash key: " + err.Error())
+	}
+	return key
+}
+
+// LoadTokenHashKey reads the token hash key from path, generating and saving a new one if the file doesn't exist
+func LoadTokenHashKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		key = mustGenerateTokenHashKey()
+		if err = os.WriteFile(path, key, 0600); err != nil {
+			return nil, fmt.Errorf("failed to save token hash key: %w", err)
+		}
+		log.Printf("generated a new token hash key at %s\n", path)
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(key) < tokenHashKeySize {
+		return nil, fmt.Errorf("token hash key %s is shorter than %d bytes", path, tokenHashKeySize)
+	}
+	return key, nil
+}
+
+// HashToken is the HMAC-SHA256 of a code or refresh token with TokenHashKey, hex encoded. Only the hash
+// is stored so a dump of the stores doesn't hand out anything that can be redeemed
+func HashToken(token string) string {
+	mac := hmac.New(sha256.New, TokenHashKey)
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Fingerprint is a short prefix of a token hash to tell tokens apart in logs
+func Fingerprint(hash string) string {
+	if len(hash) < 8 {
+		return hash
+	}
+	return hash[:8]
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+func TestHashToken(t *testing.T) {
+	hash := HashToken("token")
+
+	assert.Equal(t, hash, HashToken("token"))
+	assert.NotEqual(t, hash, HashToken("other-token"))
+	assert.Len(t, hash, 64)
+	assert.Equal(t, hash[:8], Fingerprint(hash))
+}
+
+func TestHashToken_Keyed(t *testing.T) {
+	original := TokenHashKey
+	defer func() { TokenHashKey = original }()
+	hash := HashToken("token")
+
+	TokenHashKey = mustGenerateTokenHashKey()
+
+	assert.NotEqual(t, hash, HashToken("token"))
+}
+
+func TestLoadTokenHashKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token_hash.key")
+
+	generated, err := LoadTokenHashKey(path)
+	assert.Nil(t, err)
+	loaded, err := LoadTokenHashKey(path)
+	assert.Nil(t, err)
+
+	assert.Len(t, generated, tokenHashKeySize)
+	assert.Equal(t, generated, loaded)
+}
+
+func TestAuthorizationCodeStore_StoresHash(t *testing.T) {
+	acs := NewAuthCodeStore()
+	ac := NewAuthorizationCode("", "plain", "")
+
+	acs.Add(ac)
+
+	assert.NotEmpty(t, ac.Code)
+	acs.mu.Lock()
+	defer acs.mu.Unlock()
+	for hash, stored := range acs.tokenStore {
+		assert.Equal(t, HashToken(ac.Code), hash)
+		assert.Empty(t, stored.Code)
+	}
+}
+
+func TestRefreshTokenStore_StoresHash(t *testing.T) {
+	rts := NewRefreshTokenStore()
+	rt, _ := rts.Issue("", "client", "", "")
+
+	peeked, err := rts.Peek(rt.Token)
+
+	assert.Nil(t, err)
+	assert.Empty(t, peeked.Token)
+	assert.Equal(t, HashToken(rt.Token), peeked.TokenHash)
+	_, ok := rts.tokens[rt.Token]
+	assert.False(t, ok)
+}
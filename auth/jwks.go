@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// JWK is a public key in JSON Web Key format, see RFC 7517
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	// RSA members
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC and OKP members
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set, the format served on the jwks_uri
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWK converts a public key to a JWK with its RFC 7638 thumbprint as the kid
+func NewJWK(pub crypto.PublicKey, alg string) (JWK, error) {
+	jwk := JWK{
+		Use: "sig",
+		Alg: alg,
+	}
+
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(k.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+	case *ecdsa.PublicKey:
+		// coordinates are padded to the size of the curve (RFC 7518 section 6.2.1.2)
+		size := (k.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = k.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(k.X.FillBytes(make([]byte, size)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(k.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(k)
+	default:
+		return JWK{}, fmt.Errorf("unsupported public key type %T", pub)
+	}
+
+	jwk.Kid = jwk.Thumbprint()
+	return jwk, nil
+}
+
+// Thumbprint is the base64url encoded SHA-256 JWK Thumbprint (RFC 7638), which is the hash
+// of the required members of the key in lexicographic order with no whitespace
+func (j JWK) Thumbprint() string {
+	var required interface{}
+	switch j.Kty {
+	case "EC":
+		required = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{j.Crv, j.Kty, j.X, j.Y}
+	case "OKP":
+		required = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{j.Crv, j.Kty, j.X}
+	default:
+		required = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{j.E, j.Kty, j.N}
+	}
+
+	// marshalling a struct of strings can't fail
+	b, _ := json.Marshal(required)
+	sum := sha256.Sum256(b)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestJWK_Thumbprint(t *testing.T) {
+	// example from https://datatracker.ietf.org/doc/html/rfc7638#section-3.1
+	jwk := JWK{
+		Kty: "RSA",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:   "AQAB",
+		Alg: "RS256",
+		Kid: "2011-04-29",
+	}
+
+	assert.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", jwk.Thumbprint())
+}
+
+func TestKeyring_JWKS(t *testing.T) {
+	jwks := Keys.JWKS()
+
+	if assert.Len(t, jwks.Keys, 1) {
+		assert.Equal(t, "RSA", jwks.Keys[0].Kty)
+		assert.Equal(t, "RS256", jwks.Keys[0].Alg)
+		assert.Equal(t, jwks.Keys[0].Thumbprint(), jwks.Keys[0].Kid)
+	}
+}
+
+func TestSignToken_Kid(t *testing.T) {
+	jwks := Keys.JWKS()
+
+	token, err := CreateJWT(jwt.SigningMethodRS256, "", "client", Issuer)
+
+	assert.Nil(t, err)
+	assert.Equal(t, jwks.Keys[0].Kid, token.Header["kid"])
+	valid, err := ValidateToken(token.Raw)
+	assert.Nil(t, err)
+	assert.True(t, valid)
+}
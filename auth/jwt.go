@@ -1,85 +1,164 @@
 package auth
 
 import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
-	"os"
+	"github.com/google/uuid"
+	"strings"
+	"time"
 )
 
 var (
-	PathToPrivateKey = "keys/private.pem"
-	PathToPublicKey  = "keys/public.pub"
+	// Issuer is the URL the server is reached at, it's the iss of every token and the base of the discovery
+	// document's endpoints. NewServer sets it from Config.Issuer
+	Issuer            string
+	IDTokenExpiration = 1 * time.Hour
+	// AccessTokenExpiration is the lifetime of access tokens and the expires_in of the token response
+	AccessTokenExpiration = 15 * time.Minute
 )
 
+var ErrTokenRevoked = errors.New("token has been revoked")
+
+// AccessTokenType is the typ header of JWT access tokens (RFC 9068 section 2.1)
+const AccessTokenType = "at+jwt"
+
+// Claims are the claims of a JWT access token, see RFC 9068 section 2.2
 type Claims struct {
-	foo string
+	Scope    string `json:"scope,omitempty"`
+	ClientId string `json:"client_id"`
 	jwt.RegisteredClaims
 }
 
-func CreateJWT(method jwt.SigningMethod, scope ...string) (token *jwt.Token, err error) {
-	//TODO create JWT based off of client id
-	token = jwt.NewWithClaims(jwt.SigningMethodRS256, Claims{
+// CreateJWT creates an access token for subject, issued to clientId and to be used at audience.
+// It is signed with method, the keyring's default method is used when it is nil
+func CreateJWT(method jwt.SigningMethod, subject, clientId, audience string, scope ...string) (token *jwt.Token, err error) {
+	if method == nil {
+		method = Keys.DefaultMethod()
+	}
+	if method == nil {
+		return nil, errors.New("keyring has no default signing method")
+	}
+
+	now := time.Now()
+	token = jwt.NewWithClaims(method, Claims{
+		Scope:    strings.Join(scope, " "),
+		ClientId: clientId,
 		RegisteredClaims: jwt.RegisteredClaims{
-			Issuer:    "http://jakeoauth",
-			Subject:   "",
-			Audience:  jwt.ClaimStrings{},
-			ExpiresAt: nil,
-			NotBefore: nil,
-			IssuedAt:  nil,
-			ID:        "",
+			Issuer:    Issuer,
+			Subject:   subject,
+			Audience:  jwt.ClaimStrings{audience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenExpiration)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ID:        uuid.NewString(),
 		},
 	})
-	b, err := os.ReadFile(PathToPrivateKey)
+	token.Header["typ"] = AccessTokenType
 
+	err = signToken(token)
 	if err != nil {
-		fmt.Printf("failed to read private key file error: %s\n", err)
-		panic(err)
+		return nil, err
 	}
 
-	key, err := jwt.ParseRSAPrivateKeyFromPEM(b)
+	return
+}
 
-	if err != nil {
-		fmt.Printf("failed to parse private key: %s\n", err)
-		panic(err)
+// IDTokenClaims are the claims of an OpenID Connect ID Token,
+// see https://openid.net/specs/openid-connect-core-1_0.html#IDToken
+type IDTokenClaims struct {
+	Nonce    string `json:"nonce,omitempty"`
+	AuthTime int64  `json:"auth_time,omitempty"`
+	AtHash   string `json:"at_hash,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// CreateIDToken creates an ID Token for the user that authorized code, accessToken is the access token
+// issued alongside it and is used for the at_hash claim. alg is the client's id_token_signed_response_alg
+func CreateIDToken(code *AuthorizationCode, accessToken, alg string) (token *jwt.Token, err error) {
+	// the ID Token is about the user who logged in, sub is required (OpenID Connect Core section 2)
+	if code.Subject == "" || code.AuthTime == 0 {
+		return nil, errors.New("authorization code has no logged in user to issue an id token for")
 	}
 
-	tokenString, err := token.SignedString(key)
+	method := jwt.GetSigningMethod(alg)
+	if method == nil {
+		return nil, fmt.Errorf("unsupported id token signing alg `%s`", alg)
+	}
 
+	atHash, err := accessTokenHash(accessToken, alg)
 	if err != nil {
-		fmt.Printf("failed to sign string %s\n", err)
-		panic(err)
+		return nil, err
 	}
 
-	token.Raw = tokenString
+	now := time.Now()
+	token = jwt.NewWithClaims(method, IDTokenClaims{
+		Nonce:    code.Nonce,
+		AuthTime: code.AuthTime,
+		AtHash:   atHash,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    Issuer,
+			Subject:   code.Subject,
+			Audience:  jwt.ClaimStrings{code.ClientId},
+			ExpiresAt: jwt.NewNumericDate(now.Add(IDTokenExpiration)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	})
 
-	fmt.Println("token string: " + tokenString)
+	err = signToken(token)
+	if err != nil {
+		return nil, err
+	}
 
 	return
 }
 
-func ValidateToken(tokenString string) (bool, error) {
-	b, err := os.ReadFile(PathToPublicKey)
+// accessTokenHash is the base64url encoding of the left-most half of the hash of the access token,
+// using the hash algorithm of the ID Token's alg header (SHA-256 for RS256, PS256 and ES256, SHA-512 for Ed25519)
+func accessTokenHash(accessToken, alg string) (string, error) {
+	var sum []byte
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		s := sha256.Sum256([]byte(accessToken))
+		sum = s[:]
+	case "EdDSA":
+		s := sha512.Sum512([]byte(accessToken))
+		sum = s[:]
+	default:
+		return "", fmt.Errorf("no at_hash hash algorithm for alg `%s`", alg)
+	}
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2]), nil
+}
+
+// signToken signs token with the active key in the keyring and sets token.Raw to the signed string.
+// The kid header is set so validators can find the matching key in the JWKS
+func signToken(token *jwt.Token) error {
+	key, err := Keys.Active(token.Method.Alg())
 
 	if err != nil {
-		fmt.Printf("failed to read public key file %s\n", err)
-		return false, err
+		fmt.Printf("failed to get signing key: %s\n", err)
+		return err
 	}
 
-	pubKey, err := jwt.ParseRSAPublicKeyFromPEM(b)
+	token.Header["kid"] = key.Kid
+
+	tokenString, err := token.SignedString(key.Private)
 
 	if err != nil {
-		fmt.Printf("failed to parse pub key %s\n", err)
-		return false, err
+		fmt.Printf("failed to sign string %s\n", err)
+		return err
 	}
 
-	methods := jwt.WithValidMethods([]string{"RS256"})
+	token.Raw = tokenString
+	return nil
+}
 
-	token, err := jwt.NewParser(methods).Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return pubKey, nil
-	})
+func ValidateToken(tokenString string) (bool, error) {
+	token, err := ParseToken(tokenString)
 
 	if err != nil {
-		fmt.Printf("error while parsing, %s\n", err)
 		return false, err
 	}
 
@@ -90,3 +169,70 @@ func ValidateToken(tokenString string) (bool, error) {
 
 	return true, nil
 }
+
+// ParseToken verifies the signature and registered claims of tokenString and returns the parsed token
+func ParseToken(tokenString string) (*jwt.Token, error) {
+	methods := jwt.WithValidMethods(Keys.ValidationAlgorithms())
+
+	token, err := jwt.NewParser(methods).Parse(tokenString, keyFunc)
+
+	if err != nil {
+		fmt.Printf("error while parsing, %s\n", err)
+		return nil, err
+	}
+
+	if jti, ok := token.Claims.(jwt.MapClaims)["jti"].(string); ok && RevokedTokens.IsRevoked(jti) {
+		return nil, ErrTokenRevoked
+	}
+
+	return token, nil
+}
+
+// ParseAccessToken verifies tokenString like ParseToken and returns its access token claims.
+// ID Tokens are signed with the same keys so the typ header is checked too.
+// The token's aud has to contain audience, an empty audience accepts a token for any resource,
+// that's only for introspection and revocation, which handle tokens of every resource server
+func ParseAccessToken(tokenString, audience string) (*Claims, error) {
+	opts := []jwt.ParserOption{jwt.WithValidMethods(Keys.ValidationAlgorithms())}
+	if audience != "" {
+		opts = append(opts, jwt.WithAudience(audience))
+	}
+
+	claims := &Claims{}
+	token, err := jwt.NewParser(opts...).ParseWithClaims(tokenString, claims, keyFunc)
+	if err != nil {
+		return nil, err
+	}
+
+	if token.Header["typ"] != AccessTokenType {
+		return nil, errors.New("token is not an access token")
+	}
+
+	if RevokedTokens.IsRevoked(claims.ID) {
+		return nil, ErrTokenRevoked
+	}
+
+	return claims, nil
+}
+
+// keyFunc finds the public key in the keyring that token was signed with
+func keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		// tokens signed before kid was added don't have one, only the active key can validate those
+		key, err := Keys.Active(token.Method.Alg())
+		if err != nil {
+			return nil, err
+		}
+		return key.Public, nil
+	}
+
+	key, ok := Keys.Key(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %s", kid)
+	}
+	if key.Alg != token.Method.Alg() {
+		return nil, fmt.Errorf("key %s can't be used with alg %s", kid, token.Method.Alg())
+	}
+	return key.Public, nil
+}
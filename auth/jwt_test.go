@@ -1,13 +1,19 @@
 package auth
 
 import (
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func init() {
-	PathToPrivateKey = "../keys/private.pem"
-	PathToPublicKey = "../keys/public.pub"
+	var err error
+	Keys, err = LoadKeyring("../keys/private.pem")
+	if err != nil {
+		panic(err)
+	}
+	Issuer = "http://localhost:8080"
 }
 
 var rsaTestData = []struct {
@@ -50,12 +56,137 @@ var rsaTestData = []struct {
 
 func TestCreateJWT(t *testing.T) {
 
-	token, err := CreateJWT()
+	token, err := CreateJWT(jwt.SigningMethodRS256, "jakedanson", "client", "https://api.example", "openid", "profile")
 
 	assert.Nilf(t, err, "failed to create JWT: %s", err)
 	assert.NotNil(t, token)
 	assert.Equal(t, "RS256", token.Method.Alg())
+	assert.Equal(t, AccessTokenType, token.Header["typ"])
+
+	parsed, err := ParseToken(token.Raw)
+	if assert.Nil(t, err) {
+		claims := token.Claims.(Claims)
+		assert.Equal(t, "jakedanson", claims.Subject)
+		assert.Equal(t, "client", claims.ClientId)
+		assert.Equal(t, "openid profile", claims.Scope)
+		assert.Equal(t, jwt.ClaimStrings{"https://api.example"}, claims.Audience)
+		assert.NotEmpty(t, claims.ID)
+		exp, _ := parsed.Claims.GetExpirationTime()
+		iat, _ := parsed.Claims.GetIssuedAt()
+		assert.Equal(t, AccessTokenExpiration, exp.Sub(iat.Time))
+	}
+}
+
+func TestCreateJWT_UniqueJti(t *testing.T) {
+	first, _ := CreateJWT(nil, "", "client", Issuer)
+	second, _ := CreateJWT(nil, "", "client", Issuer)
+
+	assert.NotEqual(t, first.Claims.(Claims).ID, second.Claims.(Claims).ID)
+}
+
+func TestCreateIDToken(t *testing.T) {
+	code := NewAuthorizationCode("", "plain", "")
+	code.ClientId = "client"
+	code.Subject = "jakedanson"
+	code.Nonce = "n-0S6_WzA2Mj"
+	code.AuthTime = 1311280969
+
+	token, err := CreateIDToken(code, "jHkWEdUXMU1BwAsC4vtUsZwnNvTIxEl0z9K3vx5KF0Y", "RS256")
+	assert.Nilf(t, err, "failed to create ID token: %s", err)
+
+	valid, err := ValidateToken(token.Raw)
+	assert.Nil(t, err)
+	assert.True(t, valid)
+
+	claims := token.Claims.(IDTokenClaims)
+	assert.Equal(t, Issuer, claims.Issuer)
+	assert.Equal(t, "jakedanson", claims.Subject)
+	assert.Equal(t, jwt.ClaimStrings{"client"}, claims.Audience)
+	assert.Equal(t, "n-0S6_WzA2Mj", claims.Nonce)
+	assert.Equal(t, int64(1311280969), claims.AuthTime)
+	assert.NotNil(t, claims.ExpiresAt)
+	assert.NotNil(t, claims.IssuedAt)
+	// example from https://openid.net/specs/openid-connect-core-1_0.html#code-id_tokenExample
+	assert.Equal(t, "77QmUPtjPfzWtF2AnpK9RQ", claims.AtHash)
+}
+
+func TestCreateIDToken_NoUser(t *testing.T) {
+	code := NewAuthorizationCode("", "plain", "")
+	code.ClientId = "client"
+
+	_, err := CreateIDToken(code, "access-token", "RS256")
+	assert.Error(t, err)
+
+	code.Subject = "jakedanson"
+	_, err = CreateIDToken(code, "access-token", "RS256")
+	assert.Error(t, err, "auth_time is required too")
+}
+
+func TestCreateIDToken_Algorithms(t *testing.T) {
+	original := Keys
+	defer func() { Keys = original }()
+	var err error
+	Keys, err = LoadKeyring("../keys/private.pem", "../keys/ec_private.pem", "../keys/ed25519_private.pem")
+	assert.Nil(t, err)
+	pssKey, err := LoadSigningKey("../keys/pss_private.pem", "PS256")
+	assert.Nil(t, err)
+	assert.Nil(t, Keys.SetActive(pssKey))
+
+	code := NewAuthorizationCode("", "plain", "")
+	code.ClientId = "client"
+	code.Subject = "jakedanson"
+	code.AuthTime = time.Now().Unix()
+
+	for _, alg := range []string{"RS256", "PS256", "ES256", "EdDSA"} {
+		token, err := CreateIDToken(code, "access-token", alg)
+		if !assert.Nilf(t, err, "failed to create %s ID token: %s", alg, err) {
+			continue
+		}
+		assert.Equal(t, alg, token.Header["alg"])
+
+		valid, err := ValidateToken(token.Raw)
+		assert.Nil(t, err)
+		assert.True(t, valid)
+	}
+
+	_, err = CreateIDToken(code, "access-token", "HS256")
+	assert.Error(t, err)
+}
+
+func TestParseAccessToken(t *testing.T) {
+	token, _ := CreateJWT(nil, "jakedanson", "client", Issuer, "api.read")
+
+	claims, err := ParseAccessToken(token.Raw, Issuer)
+
+	if assert.Nil(t, err) {
+		assert.Equal(t, "jakedanson", claims.Subject)
+		assert.Equal(t, "client", claims.ClientId)
+		assert.Equal(t, "api.read", claims.Scope)
+		assert.Equal(t, token.Claims.(Claims).ID, claims.ID)
+	}
+}
+
+func TestParseAccessToken_Audience(t *testing.T) {
+	token, _ := CreateJWT(nil, "jakedanson", "client", "https://api.example.com", "api.read")
+
+	_, err := ParseAccessToken(token.Raw, Issuer)
+	assert.Error(t, err)
+
+	claims, err := ParseAccessToken(token.Raw, "https://api.example.com")
+	if assert.Nil(t, err) {
+		assert.Equal(t, "jakedanson", claims.Subject)
+	}
+	_, err = ParseAccessToken(token.Raw, "")
+	assert.Nil(t, err, "an empty audience accepts any resource")
+}
+
+func TestParseAccessToken_IDToken(t *testing.T) {
+	code := &AuthorizationCode{ClientId: "client", Subject: "jakedanson", AuthTime: time.Now().Unix()}
+	idToken, _ := CreateIDToken(code, "access-token", "RS256")
+
+	_, err := ParseAccessToken(idToken.Raw, "")
 
+	assert.Error(t, err)
 }
 
 func TestValidateToken(t *testing.T) {
@@ -0,0 +1,373 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// Keys is the keyring tokens are signed and validated with, main loads it on startup
+	Keys = NewKeyring()
+
+	KeyRotationInterval = 24 * time.Hour
+	// RetiredKeyLifetime is how long a retired key is kept for validation, it has to be
+	// longer than the lifetime of anything signed with it
+	RetiredKeyLifetime = 24 * time.Hour
+)
+
+// SigningKey is a key pair in the keyring. The active key for an alg signs new tokens,
+// retired keys are only kept around to validate tokens signed before a rotation
+type SigningKey struct {
+	Kid       string
+	Alg       string
+	Private   crypto.Signer
+	Public    crypto.PublicKey
+	RetiredAt time.Time
+}
+
+// NewSigningKey pairs a private key with the JWS alg it signs with. The key type has to match
+// the alg: RSA for RS256 and PS256, P-256 for ES256 and Ed25519 for EdDSA
+func NewSigningKey(private crypto.Signer, alg string) (*SigningKey, error) {
+	if err := checkKeyType(private, alg); err != nil {
+		return nil, err
+	}
+
+	key := &SigningKey{
+		Alg:     alg,
+		Private: private,
+		Public:  private.Public(),
+	}
+
+	jwk, err := NewJWK(key.Public, alg)
+	if err != nil {
+		return nil, err
+	}
+	key.Kid = jwk.Kid
+	return key, nil
+}
+
+func checkKeyType(private crypto.Signer, alg string) error {
+	ok := false
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		ok = alg == jwt.SigningMethodRS256.Alg() || alg == jwt.SigningMethodPS256.Alg()
+	case *ecdsa.PrivateKey:
+		ok = alg == jwt.SigningMethodES256.Alg() && k.Curve == elliptic.P256()
+	case ed25519.PrivateKey:
+		ok = alg == jwt.SigningMethodEdDSA.Alg()
+	}
+
+	if !ok {
+		return fmt.Errorf("a %T can't be used to sign with %s", private, alg)
+	}
+	return nil
+}
+
+// defaultAlg is the alg a key is used with when one isn't given
+func defaultAlg(private crypto.Signer) (string, error) {
+	switch private.(type) {
+	case *rsa.PrivateKey:
+		return jwt.SigningMethodRS256.Alg(), nil
+	case *ecdsa.PrivateKey:
+		return jwt.SigningMethodES256.Alg(), nil
+	case ed25519.PrivateKey:
+		return jwt.SigningMethodEdDSA.Alg(), nil
+	}
+	return "", fmt.Errorf("unsupported private key type %T", private)
+}
+
+// GenerateSigningKey creates a new key for alg
+func GenerateSigningKey(alg string) (*SigningKey, error) {
+	var private crypto.Signer
+	var err error
+
+	switch alg {
+	case jwt.SigningMethodRS256.Alg(), jwt.SigningMethodPS256.Alg():
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case jwt.SigningMethodES256.Alg():
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case jwt.SigningMethodEdDSA.Alg():
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing alg %s", alg)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate %s signing key: %w", alg, err)
+	}
+	return NewSigningKey(private, alg)
+}
+
+// LoadSigningKey reads a PEM encoded RSA, EC or Ed25519 private key from disk.
+// alg can be left empty to use RS256, ES256 or EdDSA depending on the key type
+func LoadSigningKey(path, alg string) (*SigningKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key file %s: %w", path, err)
+	}
+
+	var private crypto.Signer
+	if rsaKey, err := jwt.ParseRSAPrivateKeyFromPEM(b); err == nil {
+		private = rsaKey
+	} else if ecKey, err := jwt.ParseECPrivateKeyFromPEM(b); err == nil {
+		private = ecKey
+	} else if edKey, err := jwt.ParseEdPrivateKeyFromPEM(b); err == nil {
+		private = edKey.(crypto.Signer)
+	} else {
+		return nil, fmt.Errorf("failed to parse private key %s, only RSA, EC and Ed25519 keys are supported", path)
+	}
+
+	if alg == "" {
+		alg, err = defaultAlg(private)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return NewSigningKey(private, alg)
+}
+
+func (k *SigningKey) JWK() JWK {
+	// the key was already converted once to get its kid so this can't fail
+	jwk, _ := NewJWK(k.Public, k.Alg)
+	return jwk
+}
+
+func (k *SigningKey) Retired() bool {
+	return !k.RetiredAt.IsZero()
+}
+
+// Keyring holds every key that can currently validate a token. There is one active
+// signing key per alg, the first alg made active is the default used for access tokens
+type Keyring struct {
+	mu         *sync.RWMutex
+	keys       map[string]*SigningKey
+	active     map[string]*SigningKey
+	defaultAlg string
+}
+
+func NewKeyring() *Keyring {
+	return &Keyring{
+		mu:     &sync.RWMutex{},
+		keys:   make(map[string]*SigningKey),
+		active: make(map[string]*SigningKey),
+	}
+}
+
+// LoadKeyring reads PEM encoded private keys from disk with LoadSigningKey. The first key
+// of each alg is active, the rest are loaded as retired so tokens signed with them still validate
+func LoadKeyring(privateKeyPaths ...string) (*Keyring, error) {
+	if len(privateKeyPaths) == 0 {
+		return nil, errors.New("at least one private key is needed to load a keyring")
+	}
+
+	kr := NewKeyring()
+	for _, path := range privateKeyPaths {
+		key, err := LoadSigningKey(path, "")
+		if err != nil {
+			return nil, err
+		}
+
+		if _, activeErr := kr.Active(key.Alg); activeErr != nil {
+			err = kr.SetActive(key)
+		} else {
+			key.RetiredAt = time.Now()
+			err = kr.Add(key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return kr, nil
+}
+
+// Add adds a key to the keyring without making it active
+func (kr *Keyring) Add(key *SigningKey) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	return kr.add(key)
+}
+
+func (kr *Keyring) add(key *SigningKey) error {
+	// the kid is the key's thumbprint, so the same key can't be added for two algs
+	if existing, ok := kr.keys[key.Kid]; ok && existing.Alg != key.Alg {
+		return fmt.Errorf("key %s is already in the keyring for %s, use a separate key for %s", key.Kid, existing.Alg, key.Alg)
+	}
+	kr.keys[key.Kid] = key
+	return nil
+}
+
+// SetActive makes key the signing key for its alg, retiring the previously active key
+func (kr *Keyring) SetActive(key *SigningKey) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	if err := kr.add(key); err != nil {
+		return err
+	}
+
+	if previous, ok := kr.active[key.Alg]; ok && previous.Kid != key.Kid {
+		previous.RetiredAt = time.Now()
+	}
+	key.RetiredAt = time.Time{}
+	kr.active[key.Alg] = key
+	if kr.defaultAlg == "" {
+		kr.defaultAlg = key.Alg
+	}
+	return nil
+}
+
+// Active returns the key new tokens for alg are signed with, alg can be empty for the default alg
+func (kr *Keyring) Active(alg string) (*SigningKey, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	if alg == "" {
+		alg = kr.defaultAlg
+	}
+
+	key, ok := kr.active[alg]
+	if !ok {
+		return nil, fmt.Errorf("keyring has no active signing key for alg `%s`", alg)
+	}
+	return key, nil
+}
+
+// DefaultMethod is the signing method of the default alg
+func (kr *Keyring) DefaultMethod() jwt.SigningMethod {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	return jwt.GetSigningMethod(kr.defaultAlg)
+}
+
+// Algorithms returns the algs with an active signing key, default alg first
+func (kr *Keyring) Algorithms() []string {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	algs := make([]string, 0, len(kr.active))
+	for alg := range kr.active {
+		if alg != kr.defaultAlg {
+			algs = append(algs, alg)
+		}
+	}
+	sort.Strings(algs)
+	if kr.defaultAlg != "" {
+		algs = append([]string{kr.defaultAlg}, algs...)
+	}
+	return algs
+}
+
+// ValidationAlgorithms returns the alg of every key that can validate tokens, retired keys included
+func (kr *Keyring) ValidationAlgorithms() []string {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	algs := make([]string, 0)
+	for _, key := range kr.keys {
+		if !seen[key.Alg] {
+			seen[key.Alg] = true
+			algs = append(algs, key.Alg)
+		}
+	}
+	sort.Strings(algs)
+	return algs
+}
+
+// Key looks up a key that can validate tokens by its kid
+func (kr *Keyring) Key(kid string) (*SigningKey, bool) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	key, ok := kr.keys[kid]
+	return key, ok
+}
+
+// Keys returns every key that can validate tokens, active keys first
+func (kr *Keyring) Keys() []*SigningKey {
+	algs := kr.Algorithms()
+
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	keys := make([]*SigningKey, 0, len(kr.keys))
+	for _, alg := range algs {
+		keys = append(keys, kr.active[alg])
+	}
+	for _, key := range kr.keys {
+		if key.Retired() {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Rotate generates a new key for alg and makes it active, the old key is retired but still validates
+func (kr *Keyring) Rotate(alg string) (*SigningKey, error) {
+	key, err := GenerateSigningKey(alg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = kr.SetActive(key); err != nil {
+		return nil, err
+	}
+	log.Printf("rotated %s signing key, new kid %s\n", alg, key.Kid)
+	return key, nil
+}
+
+// Prune removes retired keys that were retired longer than RetiredKeyLifetime ago
+func (kr *Keyring) Prune() {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	for kid, key := range kr.keys {
+		if key.Retired() && time.Since(key.RetiredAt) > RetiredKeyLifetime {
+			delete(kr.keys, kid)
+			log.Printf("removed retired signing key %s\n", kid)
+		}
+	}
+}
+
+// StartRotation rotates the active key of every alg every interval until ctx is done
+func (kr *Keyring) StartRotation(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, alg := range kr.Algorithms() {
+				if _, err := kr.Rotate(alg); err != nil {
+					log.Printf("error: failed to rotate %s signing key: %s\n", alg, err)
+				}
+			}
+			kr.Prune()
+		}
+	}
+}
+
+// JWKS returns the public keys of every key that can validate tokens
+func (kr *Keyring) JWKS() *JWKS {
+	jwks := &JWKS{Keys: []JWK{}}
+	for _, key := range kr.Keys() {
+		jwks.Keys = append(jwks.Keys, key.JWK())
+	}
+	return jwks
+}
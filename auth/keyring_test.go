@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestLoadKeyring(t *testing.T) {
+	kr, err := LoadKeyring("../keys/private.pem")
+
+	assert.Nil(t, err)
+	active, err := kr.Active("")
+	assert.Nil(t, err)
+	assert.False(t, active.Retired())
+	assert.Len(t, kr.Keys(), 1)
+}
+
+func TestLoadKeyring_KeyTypes(t *testing.T) {
+	kr, err := LoadKeyring("../keys/private.pem", "../keys/ec_private.pem", "../keys/ed25519_private.pem")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"RS256", "ES256", "EdDSA"}, kr.Algorithms())
+	assert.Equal(t, "RS256", kr.DefaultMethod().Alg())
+	for _, jwk := range kr.JWKS().Keys {
+		assert.Equal(t, jwk.Thumbprint(), jwk.Kid)
+	}
+}
+
+func TestLoadSigningKey_WrongAlg(t *testing.T) {
+	_, err := LoadSigningKey("../keys/ec_private.pem", "RS256")
+
+	assert.Error(t, err)
+}
+
+func TestKeyring_SameKeyTwoAlgs(t *testing.T) {
+	kr, _ := LoadKeyring("../keys/private.pem")
+	pssKey, err := LoadSigningKey("../keys/private.pem", "PS256")
+	assert.Nil(t, err)
+
+	assert.Error(t, kr.SetActive(pssKey))
+}
+
+func TestLoadKeyring_MissingFile(t *testing.T) {
+	_, err := LoadKeyring("../keys/does-not-exist.pem")
+
+	assert.Error(t, err)
+}
+
+func TestKeyring_Rotate(t *testing.T) {
+	original := Keys
+	defer func() { Keys = original }()
+	Keys, _ = LoadKeyring("../keys/private.pem")
+	oldKey, _ := Keys.Active("RS256")
+
+	oldToken, err := CreateJWT(jwt.SigningMethodRS256, "", "client", Issuer)
+	assert.Nil(t, err)
+
+	newKey, err := Keys.Rotate("RS256")
+	assert.Nil(t, err)
+	assert.NotEqual(t, oldKey.Kid, newKey.Kid)
+	assert.True(t, oldKey.Retired())
+	assert.Len(t, Keys.JWKS().Keys, 2)
+	assert.Equal(t, newKey.Kid, Keys.JWKS().Keys[0].Kid)
+
+	newToken, err := CreateJWT(jwt.SigningMethodRS256, "", "client", Issuer)
+	assert.Nil(t, err)
+	assert.Equal(t, newKey.Kid, newToken.Header["kid"])
+
+	// tokens signed before the rotation still validate with the retired key
+	valid, err := ValidateToken(oldToken.Raw)
+	assert.Nil(t, err)
+	assert.True(t, valid)
+	valid, err = ValidateToken(newToken.Raw)
+	assert.Nil(t, err)
+	assert.True(t, valid)
+}
+
+func TestKeyring_Prune(t *testing.T) {
+	RetiredKeyLifetime = 0 // this is a global var set in keyring.go
+	defer func() { RetiredKeyLifetime = 24 * time.Hour }()
+	kr, _ := LoadKeyring("../keys/private.pem")
+	oldKey, _ := kr.Active("RS256")
+	_, err := kr.Rotate("RS256")
+	assert.Nil(t, err)
+
+	time.Sleep(time.Millisecond)
+	kr.Prune()
+
+	_, ok := kr.Key(oldKey.Kid)
+	assert.False(t, ok)
+	assert.Len(t, kr.Keys(), 1)
+}
@@ -2,10 +2,12 @@ package auth
 
 import (
 	"container/heap"
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"errors"
+	"github.com/google/uuid"
 	"log"
 	"sync"
 	"time"
@@ -14,14 +16,35 @@ import (
 var (
 	CodeExpiration = 5 * time.Minute
 	asciiCharset   = []byte("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789")
+
+	ErrCodeNotFound = errors.New("token not found in code store")
+	ErrCodeReplayed = errors.New("authorization code has already been redeemed")
 )
 
 type AuthorizationCode struct {
-	Exp        int64
+	Exp int64
+	// Code is only set on the code sent to the client, stores only keep CodeHash (see HashToken)
 	Code       string
+	CodeHash   string
 	Pkce       string
 	HashMethod string
 	State      string
+
+	// Values from the authorization request the code is bound to (RFC 6749 section 4.1.3)
+	ClientId    string
+	RedirectUri string
+	Scope       string
+	Subject     string
+	// RequestedScope is the scope from the authorization request, Scope is what was granted
+	RequestedScope string
+
+	// OpenID Connect values carried into the ID Token
+	Nonce    string
+	AuthTime int64
+
+	// GrantId identifies everything issued from this code, refresh tokens use it as their family
+	GrantId  string
+	Redeemed bool
 }
 
 func NewAuthorizationCode(pkceCode, hashMethod, state string) *AuthorizationCode {
@@ -35,6 +58,7 @@ func NewAuthorizationCode(pkceCode, hashMethod, state string) *AuthorizationCode
 		Pkce:       pkceCode,
 		HashMethod: hashMethod,
 		State:      state,
+		GrantId:    uuid.NewString(),
 	}
 }
 
@@ -79,79 +103,202 @@ func (h *TokenHeap) Pop() interface{} {
 	return x
 }
 
-// AuthorizationCodeStore used to store auth code after authorization endpoint
+// AuthorizationCodeStore used to store auth code after authorization endpoint.
+// Codes are removed when they expire by the scheduler started with StartExpiration
 type AuthorizationCodeStore struct {
-	heapMu    *sync.Mutex
-	tokenHeap TokenHeap
-
-	tokenMu    *sync.Mutex
+	// mu guards both the heap and the map, they always change together
+	mu         *sync.Mutex
+	tokenHeap  TokenHeap
 	tokenStore map[string]*AuthorizationCode
 
-	ch chan *AuthorizationCode
+	// wake tells the scheduler the earliest expiration changed, it holds at most one pending wake up
+	wake   chan struct{}
+	events *eventBroker
 }
 
 func NewAuthCodeStore() (acs *AuthorizationCodeStore) {
 	acs = &AuthorizationCodeStore{
+		mu:         &sync.Mutex{},
 		tokenHeap:  make(TokenHeap, 0),
-		heapMu:     &sync.Mutex{},
-		tokenMu:    &sync.Mutex{},
 		tokenStore: make(map[string]*AuthorizationCode),
-		ch:         make(chan *AuthorizationCode),
+		wake:       make(chan struct{}, 1),
+		events:     newEventBroker(),
 	}
 	heap.Init(&acs.tokenHeap)
-	go acs.startExpirationTimer()
 	return
 }
 
-func (acs *AuthorizationCodeStore) startExpirationTimer() {
+// StartExpiration removes codes from the store as they expire until ctx is done, then closes
+// every Subscription. The scheduler sleeps until the earliest expiration and is woken up early
+// when a code that expires sooner is added
+func (acs *AuthorizationCodeStore) StartExpiration(ctx context.Context) {
+	defer acs.events.close()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
 	for {
-		if acs.tokenHeap.Len() == 0 {
-			time.Sleep(time.Second)
-			continue
+		expired, next, ok := acs.popExpired(time.Now())
+		for _, code := range expired {
+			acs.events.publish(CodeExpired, *code)
+		}
+
+		// since go 1.23 Reset drops a fire that wasn't received, so the timer doesn't need draining
+		var timerC <-chan time.Time
+		if ok {
+			timer.Reset(time.Until(next))
+			timerC = timer.C
 		}
 
-		acs.heapMu.Lock()
-		nextExpiration := acs.tokenHeap[0].Exp
-		now := time.Now()
-		if time.Unix(nextExpiration, 0).After(time.Now()) {
-			acs.heapMu.Unlock()
-			time.Sleep(time.Unix(nextExpiration, 0).Sub(now))
-			continue
+		select {
+		case <-ctx.Done():
+			return
+		case <-acs.wake:
+		case <-timerC:
+		}
+	}
+}
+
+// popExpired removes every code that has expired by now and returns them, along with when the
+// next code expires. ok is false if the store is empty
+func (acs *AuthorizationCodeStore) popExpired(now time.Time) (expired []*AuthorizationCode, next time.Time, ok bool) {
+	acs.mu.Lock()
+	defer acs.mu.Unlock()
+
+	for acs.tokenHeap.Len() > 0 {
+		exp := time.Unix(acs.tokenHeap[0].Exp, 0)
+		if exp.After(now) {
+			return expired, exp, true
 		}
 
-		acs.tokenMu.Lock()
-		expiredToken := acs.tokenHeap.Pop().(*AuthorizationCode)
-		delete(acs.tokenStore, expiredToken.Code)
-		acs.ch <- expiredToken
-		acs.heapMu.Unlock()
-		acs.tokenMu.Unlock()
+		code := heap.Pop(&acs.tokenHeap).(*AuthorizationCode)
+		delete(acs.tokenStore, code.CodeHash)
+		expired = append(expired, code)
 	}
+	return expired, time.Time{}, false
+}
+
+// Subscribe returns a Subscription to every code issued, redeemed, expired or replayed from now on.
+// buffer is how many events can wait for the subscriber before new ones are dropped
+func (acs *AuthorizationCodeStore) Subscribe(buffer int) *Subscription {
+	return acs.events.subscribe(buffer)
 }
 
+// ListenExpiration logs every code that expires, it returns once the expiration scheduler stops
 func (acs *AuthorizationCodeStore) ListenExpiration() {
-	for expCode := range acs.ch {
-		log.Printf("Code %s expired\n", expCode.Code)
+	sub := acs.Subscribe(100)
+	for e := range sub.C {
+		if e.Type == CodeExpired {
+			log.Printf("Code %s expired\n", Fingerprint(e.Code.CodeHash))
+		}
+	}
+	if dropped := sub.Dropped(); dropped > 0 {
+		log.Printf("ListenExpiration missed %d code events\n", dropped)
 	}
+}
 
+// Len is the number of codes in the store, redeemed codes are kept until they expire
+func (acs *AuthorizationCodeStore) Len() int {
+	acs.mu.Lock()
+	defer acs.mu.Unlock()
+	return len(acs.tokenStore)
 }
 
-// Add adds an authorization code to the store with expiration & pkce requirements
-func (acs *AuthorizationCodeStore) Add(code *AuthorizationCode) {
-	acs.heapMu.Lock()
-	acs.tokenMu.Lock()
-	acs.tokenHeap.Push(code)
-	acs.tokenStore[code.Code] = code
-	acs.heapMu.Unlock()
-	acs.tokenMu.Unlock()
+// Add adds an authorization code to the store with expiration & pkce requirements.
+// The store keeps a copy with only the hash of the code
+func (acs *AuthorizationCodeStore) Add(code *AuthorizationCode) error {
+	stored := *code
+	stored.CodeHash = HashToken(code.Code)
+	stored.Code = ""
+
+	acs.mu.Lock()
+	heap.Push(&acs.tokenHeap, &stored)
+	acs.tokenStore[stored.CodeHash] = &stored
+	earliest := acs.tokenHeap[0] == &stored
+	issued := stored
+	acs.mu.Unlock()
+
+	acs.events.publish(CodeIssued, issued)
+
+	if earliest {
+		// the scheduler might be sleeping until a later expiration
+		select {
+		case acs.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Redeem checks the code against the pkce verifier and makes sure the client redeeming it
+// is the one it was issued to, with the same redirect_uri used in the authorization request.
+// A code can only be redeemed once, any later attempt returns the code along with ErrCodeReplayed
+// so the caller can revoke whatever was issued from it (RFC 6749 section 10.5)
+func (acs *AuthorizationCodeStore) Redeem(authCode, pkceCode, clientId, redirectUri string) (*AuthorizationCode, error) {
+	code, err := acs.redeem(authCode, pkceCode, clientId, redirectUri)
+	if errors.Is(err, ErrCodeReplayed) {
+		acs.events.publish(CodeReplayed, *code)
+	} else if err == nil {
+		acs.events.publish(CodeRedeemed, *code)
+	}
+	return code, err
+}
+
+// redeem returns a copy of the code, the one in the store can't be read without holding mu
+func (acs *AuthorizationCodeStore) redeem(authCode, pkceCode, clientId, redirectUri string) (*AuthorizationCode, error) {
+	acs.mu.Lock()
+	defer acs.mu.Unlock()
+
+	val, ok := acs.tokenStore[HashToken(authCode)]
+	if !ok {
+		return nil, ErrCodeNotFound
+	}
+
+	if val.Redeemed {
+		code := *val
+		return &code, ErrCodeReplayed
+	}
+
+	if err := val.Verify(pkceCode, clientId, redirectUri); err != nil {
+		return nil, err
+	}
+
+	val.Redeemed = true
+	code := *val
+	return &code, nil
+}
+
+// Verify checks the token request redeeming the code matches the authorization request it was issued for.
+// It doesn't check if the code was already redeemed, that is up to the store
+func (val *AuthorizationCode) Verify(pkceCode, clientId, redirectUri string) error {
+	if isValid, err := checkPkce(val, pkceCode); !isValid {
+		return err
+	}
+
+	if val.ClientId != clientId {
+		return errors.New("client_id was not the same as the authorization request")
+	}
+
+	if val.RedirectUri != redirectUri {
+		return errors.New("redirect_uri was not the same as the authorization request")
+	}
+
+	return nil
 }
 
 func (acs *AuthorizationCodeStore) CheckTokenWithPkce(authCode, pkceCode string) (bool, error) {
-	val, ok := acs.tokenStore[authCode]
+	acs.mu.Lock()
+	val, ok := acs.tokenStore[HashToken(authCode)]
+	acs.mu.Unlock()
 
 	if !ok {
 		return false, errors.New("token not found in code store")
 	}
 
+	return checkPkce(val, pkceCode)
+}
+
+func checkPkce(val *AuthorizationCode, pkceCode string) (bool, error) {
 	if time.Unix(val.Exp, 0).Before(time.Now()) {
 		// should never get here because the token gets removed as soon as it is expired...
 		return false, errors.New("token is expired")
@@ -168,7 +315,7 @@ func (acs *AuthorizationCodeStore) CheckTokenWithPkce(authCode, pkceCode string)
 	codeChallenge := base64.RawURLEncoding.EncodeToString(hashedPkce[:])
 
 	if codeChallenge != val.Pkce {
-		return false, errors.New("pkce challenge was not the same as pkce verifier after hash")
+		return false, errors.New("pkce code was not the same")
 	}
 
 	return true, nil
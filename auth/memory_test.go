@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
@@ -22,6 +23,9 @@ func TestNewAuthCodeStore(t *testing.T) {
 	code, _ := generateASCII(45)
 	CodeExpiration = 1 * time.Second // this is a global var set in memory.go
 	acs := NewAuthCodeStore()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go acs.StartExpiration(ctx)
 	go acs.ListenExpiration()
 	for i := 0; i <= 200; i++ {
 		ac := NewAuthorizationCode(code, "S256", "")
@@ -29,8 +33,81 @@ func TestNewAuthCodeStore(t *testing.T) {
 	}
 
 	time.Sleep(3 * time.Second)
-	assert.Equal(t, 0, len(acs.tokenStore))
+	assert.Equal(t, 0, acs.Len())
+	acs.mu.Lock()
 	assert.Equal(t, 0, acs.tokenHeap.Len())
+	acs.mu.Unlock()
+}
+
+func TestAuthorizationCodeStore_StartExpiration_EarlierCodeWakesScheduler(t *testing.T) {
+	acs := NewAuthCodeStore()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go acs.StartExpiration(ctx)
+	go acs.ListenExpiration()
+
+	late := NewAuthorizationCode("", "plain", "")
+	late.Exp = time.Now().Add(time.Hour).Unix()
+	acs.Add(late)
+	// give the scheduler time to go to sleep until the late code expires
+	time.Sleep(50 * time.Millisecond)
+
+	early := NewAuthorizationCode("", "plain", "")
+	early.Exp = time.Now().Unix()
+	acs.Add(early)
+
+	assert.Eventually(t, func() bool { return acs.Len() == 1 }, 2*time.Second, 10*time.Millisecond)
+	_, err := acs.CheckTokenWithPkce(early.Code, "")
+	assert.Error(t, err)
+	_, err = acs.CheckTokenWithPkce(late.Code, "")
+	assert.Nil(t, err)
+}
+
+func TestAuthorizationCodeStore_StartExpiration_HeapOrder(t *testing.T) {
+	acs := NewAuthCodeStore()
+	now := time.Now().Unix()
+	for _, offset := range []int64{5, -3, 60, -1, 30, -2, 10} {
+		ac := NewAuthorizationCode("", "plain", "")
+		ac.Exp = now + offset
+		acs.Add(ac)
+	}
+
+	expired, next, ok := acs.popExpired(time.Unix(now, 0))
+
+	assert.Len(t, expired, 3)
+	for i := 1; i < len(expired); i++ {
+		assert.LessOrEqual(t, expired[i-1].Exp, expired[i].Exp)
+	}
+	assert.True(t, ok)
+	assert.Equal(t, now+5, next.Unix())
+	assert.Equal(t, 4, acs.Len())
+}
+
+func TestAuthorizationCodeStore_StartExpiration_Shutdown(t *testing.T) {
+	acs := NewAuthCodeStore()
+	// nobody reads from sub, the expired event is dropped but shutdown still has to work
+	sub := acs.Subscribe(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		acs.StartExpiration(ctx)
+		close(done)
+	}()
+
+	ac := NewAuthorizationCode("", "plain", "")
+	ac.Exp = time.Now().Add(-time.Second).Unix()
+	acs.Add(ac)
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("StartExpiration did not return after the context was cancelled")
+	}
+	_, open := <-sub.C
+	assert.False(t, open)
+	assert.Equal(t, uint64(2), sub.Dropped())
 }
 
 func TestAuthorizationCodeStore_CheckTokenWithPkce_Valid(t *testing.T) {
@@ -39,7 +116,6 @@ func TestAuthorizationCodeStore_CheckTokenWithPkce_Valid(t *testing.T) {
 	codeChallenge := base64.RawURLEncoding.EncodeToString(shaBytes[:])
 	CodeExpiration = 5 * time.Minute
 	acs := NewAuthCodeStore()
-	go acs.ListenExpiration()
 
 	ac := NewAuthorizationCode(codeChallenge, "S256", "")
 	acs.Add(ac)
@@ -56,7 +132,6 @@ func TestAuthorizationCodeStore_CheckTokenWithPkce_Expired(t *testing.T) {
 	shaBytes := sha256.Sum256([]byte(pkce))
 	codeChallenge := base64.RawURLEncoding.EncodeToString(shaBytes[:])
 	acs := NewAuthCodeStore()
-	go acs.ListenExpiration()
 
 	ac := NewAuthorizationCode(codeChallenge, "S256", "")
 	acs.Add(ac)
@@ -73,7 +148,6 @@ func TestAuthorizationCodeStore_CheckTokenWithPkce_NotInCodeStore(t *testing.T)
 	shaBytes := sha256.Sum256([]byte(pkce))
 	codeChallenge := base64.RawURLEncoding.EncodeToString(shaBytes[:])
 	acs := NewAuthCodeStore()
-	go acs.ListenExpiration()
 
 	ac := NewAuthorizationCode(codeChallenge, "S256", "")
 	time.Sleep(20 * time.Microsecond)
@@ -91,7 +165,6 @@ func TestAuthorizationCodeStore_CheckTokenWithPkce_InvalidPkce(t *testing.T) {
 	shaBytes := sha256.Sum256([]byte(pkce))
 	codeChallenge := base64.RawURLEncoding.EncodeToString(shaBytes[:])
 	acs := NewAuthCodeStore()
-	go acs.ListenExpiration()
 
 	ac := NewAuthorizationCode(codeChallenge, "S256", "")
 	acs.Add(ac)
@@ -108,3 +181,139 @@ func TestAuthorizationCodeStore_CheckTokenWithPkce_InvalidPkce(t *testing.T) {
 	}
 	assert.False(t, isValid)
 }
+
+func TestAuthorizationCodeStore_Redeem_Valid(t *testing.T) {
+	CodeExpiration = 5 * time.Minute
+	pkce, _ := generateASCII(45)
+	shaBytes := sha256.Sum256([]byte(pkce))
+	codeChallenge := base64.RawURLEncoding.EncodeToString(shaBytes[:])
+	acs := NewAuthCodeStore()
+
+	ac := NewAuthorizationCode(codeChallenge, "S256", "")
+	ac.ClientId = "client"
+	ac.RedirectUri = "https://client.example/callback"
+	ac.Scope = "openid"
+	acs.Add(ac)
+
+	redeemed, err := acs.Redeem(ac.Code, pkce, "client", "https://client.example/callback")
+
+	assert.Nil(t, err)
+	if assert.NotNil(t, redeemed) {
+		assert.Equal(t, "openid", redeemed.Scope)
+	}
+}
+
+func TestAuthorizationCodeStore_Redeem_WrongClient(t *testing.T) {
+	CodeExpiration = 5 * time.Minute
+	pkce, _ := generateASCII(45)
+	acs := NewAuthCodeStore()
+
+	ac := NewAuthorizationCode(pkce, "plain", "")
+	ac.ClientId = "client"
+	acs.Add(ac)
+
+	redeemed, err := acs.Redeem(ac.Code, pkce, "other-client", "")
+
+	if assert.Error(t, err) {
+		assert.Equal(t, "client_id was not the same as the authorization request", err.Error())
+	}
+	assert.Nil(t, redeemed)
+}
+
+func TestAuthorizationCodeStore_Redeem_WrongRedirectUri(t *testing.T) {
+	CodeExpiration = 5 * time.Minute
+	pkce, _ := generateASCII(45)
+	acs := NewAuthCodeStore()
+
+	ac := NewAuthorizationCode(pkce, "plain", "")
+	ac.ClientId = "client"
+	ac.RedirectUri = "https://client.example/callback"
+	acs.Add(ac)
+
+	redeemed, err := acs.Redeem(ac.Code, pkce, "client", "https://attacker.example/callback")
+
+	if assert.Error(t, err) {
+		assert.Equal(t, "redirect_uri was not the same as the authorization request", err.Error())
+	}
+	assert.Nil(t, redeemed)
+}
+
+func TestAuthorizationCodeStore_Redeem_Replayed(t *testing.T) {
+	CodeExpiration = 5 * time.Minute
+	pkce, _ := generateASCII(45)
+	acs := NewAuthCodeStore()
+
+	ac := NewAuthorizationCode(pkce, "plain", "")
+	ac.ClientId = "client"
+	acs.Add(ac)
+
+	_, err := acs.Redeem(ac.Code, pkce, "client", "")
+	assert.Nil(t, err)
+
+	replayed, err := acs.Redeem(ac.Code, pkce, "client", "")
+
+	assert.ErrorIs(t, err, ErrCodeReplayed)
+	if assert.NotNil(t, replayed) {
+		assert.Equal(t, ac.GrantId, replayed.GrantId)
+	}
+}
+
+// outstandingCodes fills acs with n codes expiring over the next hour
+func outstandingCodes(acs *AuthorizationCodeStore, n int) {
+	now := time.Now()
+	for i := 0; i < n; i++ {
+		ac := NewAuthorizationCode("", "plain", "")
+		ac.Exp = now.Add(time.Duration(i%3600)*time.Second + time.Minute).Unix()
+		acs.Add(ac)
+	}
+}
+
+// BenchmarkAuthorizationCodeStore_Add is issuing and redeeming a code while 100k others are outstanding
+func BenchmarkAuthorizationCodeStore_Add(b *testing.B) {
+	acs := NewAuthCodeStore()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go acs.StartExpiration(ctx)
+	go acs.ListenExpiration()
+	outstandingCodes(acs, 100_000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ac := NewAuthorizationCode("", "plain", "")
+		ac.ClientId = "client"
+		acs.Add(ac)
+		if _, err := acs.Redeem(ac.Code, "", "client", ""); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkAuthorizationCodeStore_Expire is the scheduler removing 100k codes that expire at once
+func BenchmarkAuthorizationCodeStore_Expire(b *testing.B) {
+	const n = 100_000
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		acs := NewAuthCodeStore()
+		exp := time.Now().Unix()
+		for j := 0; j < n; j++ {
+			ac := NewAuthorizationCode("", "plain", "")
+			ac.Exp = exp
+			acs.Add(ac)
+		}
+		sub := acs.Subscribe(n)
+		ctx, cancel := context.WithCancel(context.Background())
+		b.StartTimer()
+
+		go acs.StartExpiration(ctx)
+		for j := 0; j < n; j++ {
+			<-sub.C
+		}
+
+		b.StopTimer()
+		cancel()
+		if acs.Len() != 0 {
+			b.Fatalf("%d codes were not expired", acs.Len())
+		}
+		b.StartTimer()
+	}
+}
@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	RefreshTokenExpiration = 24 * time.Hour
+
+	ErrRefreshTokenNotFound = errors.New("refresh token not found in token store")
+)
+
+type RefreshToken struct {
+	// Id identifies the token without revealing it, it's the jti when the token is introspected
+	Id       string
+	Exp      int64
+	IssuedAt int64
+	// Token is only set on the token sent to the client, stores only keep TokenHash (see HashToken)
+	Token     string
+	TokenHash string
+	ClientId  string
+	Subject   string
+	Scope     string
+	// FamilyId is shared by every token rotated from the same original grant
+	FamilyId string
+	Used     bool
+}
+
+// RefreshTokenStore keeps issued refresh tokens and the family each one belongs to.
+// Refresh tokens are one time use, a used token being presented again means it
+// has leaked so the whole family gets revoked (RFC 6749 section 10.4 / OAuth 2.0 Security BCP 4.14.2)
+type RefreshTokenStore struct {
+	mu *sync.Mutex
+	// tokens and families are both by token hash
+	tokens   map[string]*RefreshToken
+	families map[string][]string
+}
+
+func NewRefreshTokenStore() *RefreshTokenStore {
+	return &RefreshTokenStore{
+		mu:       &sync.Mutex{},
+		tokens:   make(map[string]*RefreshToken),
+		families: make(map[string][]string),
+	}
+}
+
+// Issue creates a refresh token for a new grant, starting a new token family.
+// familyId should identify the grant (e.g. the authorization code's GrantId) so it can be revoked later,
+// a random one is used if it is empty
+func (rts *RefreshTokenStore) Issue(familyId, clientId, subject, scope string) (*RefreshToken, error) {
+	if familyId == "" {
+		familyId = uuid.NewString()
+	}
+	rts.mu.Lock()
+	defer rts.mu.Unlock()
+	return rts.issue(clientId, subject, scope, familyId)
+}
+
+func (rts *RefreshTokenStore) issue(clientId, subject, scope, familyId string) (*RefreshToken, error) {
+	rt, err := NewRefreshToken(familyId, clientId, subject, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := *rt
+	stored.Token = ""
+	rts.tokens[stored.TokenHash] = &stored
+	rts.families[familyId] = append(rts.families[familyId], stored.TokenHash)
+	return rt, nil
+}
+
+// NewRefreshToken generates a refresh token in the family familyId, it isn't stored anywhere yet
+func NewRefreshToken(familyId, clientId, subject, scope string) (*RefreshToken, error) {
+	token, err := generateASCII(48)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	return &RefreshToken{
+		Id:        uuid.NewString(),
+		Exp:       now.Add(RefreshTokenExpiration).Unix(),
+		IssuedAt:  now.Unix(),
+		Token:     token,
+		TokenHash: HashToken(token),
+		ClientId:  clientId,
+		Subject:   subject,
+		Scope:     scope,
+		FamilyId:  familyId,
+	}, nil
+}
+
+// Peek returns a copy of a refresh token without redeeming it
+func (rts *RefreshTokenStore) Peek(token string) (RefreshToken, error) {
+	rts.mu.Lock()
+	defer rts.mu.Unlock()
+
+	rt, ok := rts.tokens[HashToken(token)]
+	if !ok {
+		return RefreshToken{}, ErrRefreshTokenNotFound
+	}
+	return *rt, nil
+}
+
+// Active is true if the token can still be redeemed
+func (rt RefreshToken) Active() bool {
+	return !rt.Used && time.Unix(rt.Exp, 0).After(time.Now())
+}
+
+// Rotate redeems a refresh token for the given client and returns its replacement.
+// If the token was already redeemed, every token in its family is revoked.
+func (rts *RefreshTokenStore) Rotate(token, clientId string) (*RefreshToken, error) {
+	rts.mu.Lock()
+	defer rts.mu.Unlock()
+
+	rt, ok := rts.tokens[HashToken(token)]
+	if !ok {
+		return nil, ErrRefreshTokenNotFound
+	}
+
+	if revokeFamily, err := rt.CheckRotate(clientId); err != nil {
+		if revokeFamily {
+			rts.revokeFamily(rt.FamilyId)
+		}
+		return nil, err
+	}
+
+	rt.Used = true
+	return rts.issue(rt.ClientId, rt.Subject, rt.Scope, rt.FamilyId)
+}
+
+// CheckRotate checks rt can be rotated by clientId. When it can't because the token was reused or
+// has expired, revokeFamily is true and the store has to revoke the token's whole family
+func (rt *RefreshToken) CheckRotate(clientId string) (revokeFamily bool, err error) {
+	if rt.ClientId != clientId {
+		return false, errors.New("refresh token was not issued to this client")
+	}
+
+	if rt.Used {
+		log.Printf("refresh token reuse detected, revoking token family %s\n", rt.FamilyId)
+		return true, errors.New("refresh token has already been used")
+	}
+
+	if time.Unix(rt.Exp, 0).Before(time.Now()) {
+		return true, errors.New("refresh token is expired")
+	}
+
+	return false, nil
+}
+
+// RevokeFamily removes every refresh token descended from the same grant
+func (rts *RefreshTokenStore) RevokeFamily(familyId string) error {
+	rts.mu.Lock()
+	defer rts.mu.Unlock()
+	rts.revokeFamily(familyId)
+	return nil
+}
+
+// Prune removes the families whose newest token has expired, the tokens rotated before it expired earlier
+func (rts *RefreshTokenStore) Prune() {
+	rts.mu.Lock()
+	defer rts.mu.Unlock()
+
+	now := time.Now().Unix()
+	for familyId, hashes := range rts.families {
+		newest, ok := rts.tokens[hashes[len(hashes)-1]]
+		if !ok || now >= newest.Exp {
+			rts.revokeFamily(familyId)
+		}
+	}
+}
+
+// StartPruning prunes the store every interval until ctx is done
+func (rts *RefreshTokenStore) StartPruning(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rts.Prune()
+		}
+	}
+}
+
+func (rts *RefreshTokenStore) revokeFamily(familyId string) {
+	for _, hash := range rts.families[familyId] {
+		delete(rts.tokens, hash)
+	}
+	delete(rts.families, familyId)
+}
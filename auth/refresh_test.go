@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRefreshTokenStore_Rotate(t *testing.T) {
+	rts := NewRefreshTokenStore()
+	rt, err := rts.Issue("", "client", "jakedanson", "openid")
+	assert.Nil(t, err)
+
+	rotated, err := rts.Rotate(rt.Token, "client")
+
+	assert.Nil(t, err)
+	assert.NotEqual(t, rt.Token, rotated.Token)
+	assert.Equal(t, rt.FamilyId, rotated.FamilyId)
+	assert.Equal(t, "jakedanson", rotated.Subject)
+	assert.Equal(t, "openid", rotated.Scope)
+}
+
+func TestRefreshTokenStore_Rotate_WrongClient(t *testing.T) {
+	rts := NewRefreshTokenStore()
+	rt, _ := rts.Issue("", "client", "", "")
+
+	rotated, err := rts.Rotate(rt.Token, "other-client")
+
+	if assert.Error(t, err) {
+		assert.Equal(t, "refresh token was not issued to this client", err.Error())
+	}
+	assert.Nil(t, rotated)
+}
+
+func TestRefreshTokenStore_Rotate_ReuseRevokesFamily(t *testing.T) {
+	rts := NewRefreshTokenStore()
+	rt, _ := rts.Issue("", "client", "", "")
+	rotated, _ := rts.Rotate(rt.Token, "client")
+
+	_, err := rts.Rotate(rt.Token, "client")
+	if assert.Error(t, err) {
+		assert.Equal(t, "refresh token has already been used", err.Error())
+	}
+
+	// the legitimate holder's newest token is revoked along with the rest of the family
+	_, err = rts.Rotate(rotated.Token, "client")
+	if assert.Error(t, err) {
+		assert.Equal(t, "refresh token not found in token store", err.Error())
+	}
+}
+
+func TestRefreshTokenStore_Rotate_Expired(t *testing.T) {
+	RefreshTokenExpiration = -1 * time.Second // this is a global var set in refresh.go
+	defer func() { RefreshTokenExpiration = 24 * time.Hour }()
+	rts := NewRefreshTokenStore()
+	rt, _ := rts.Issue("", "client", "", "")
+
+	_, err := rts.Rotate(rt.Token, "client")
+
+	if assert.Error(t, err) {
+		assert.Equal(t, "refresh token is expired", err.Error())
+	}
+}
+
+func TestRefreshToken_Active(t *testing.T) {
+	rts := NewRefreshTokenStore()
+	rt, _ := rts.Issue("", "client", "", "")
+	peeked, _ := rts.Peek(rt.Token)
+	assert.True(t, peeked.Active())
+
+	_, _ = rts.Rotate(rt.Token, "client")
+
+	peeked, _ = rts.Peek(rt.Token)
+	assert.False(t, peeked.Active())
+}
+
+func TestRefreshTokenStore_RevokeFamily(t *testing.T) {
+	rts := NewRefreshTokenStore()
+	rt, _ := rts.Issue("grant", "client", "", "")
+	rotated, _ := rts.Rotate(rt.Token, "client")
+	assert.Equal(t, "grant", rotated.FamilyId)
+
+	rts.RevokeFamily("grant")
+
+	_, err := rts.Rotate(rotated.Token, "client")
+	assert.Error(t, err)
+}
+
+func TestRefreshTokenStore_Prune(t *testing.T) {
+	rts := NewRefreshTokenStore()
+	expired, _ := rts.Issue("expired", "client", "", "")
+	rts.tokens[expired.TokenHash].Exp = time.Now().Add(-time.Second).Unix()
+	// the first token of this family has expired, but it was rotated into one that hasn't
+	rotated, _ := rts.Issue("rotated", "client", "", "")
+	newest, _ := rts.Rotate(rotated.Token, "client")
+	rts.tokens[rotated.TokenHash].Exp = time.Now().Add(-time.Second).Unix()
+
+	rts.Prune()
+
+	assert.NotContains(t, rts.families, "expired")
+	_, err := rts.Peek(expired.Token)
+	assert.ErrorIs(t, err, ErrRefreshTokenNotFound)
+	_, err = rts.Peek(newest.Token)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// AuthorizationRequestExpiration is how long the user has to log in and consent
+	AuthorizationRequestExpiration = 10 * time.Minute
+
+	ErrAuthorizationRequestNotFound = errors.New("authorization request not found or expired")
+)
+
+// AuthorizationRequest is a validated request to the authorization endpoint, kept on the server while
+// the user logs in and consents. The pages only pass its Id around, so nothing in the request can be
+// changed along the way
+type AuthorizationRequest struct {
+	Id  string
+	Exp int64
+
+	ClientId string
+	// RedirectUri is the redirect uri the response goes to, RequestedRedirectUri is the redirect_uri
+	// parameter the code has to be redeemed with, empty when it was left out
+	RedirectUri          string
+	RequestedRedirectUri string
+	State                string
+	Scope                string
+	RequestedScope       string
+	CodeChallenge        string
+	CodeChallengeMethod  string
+	Nonce                string
+	// Prompt is the space delimited prompt parameter, MaxAge is the max_age parameter in seconds
+	// and -1 when it wasn't sent (OpenID Connect Core section 3.1.2.1)
+	Prompt string
+	MaxAge int64
+
+	// Subject is set once the user has logged in on the login page for this request, the session may
+	// be older than the request otherwise
+	Subject string
+	// ConsentedBy is the user that allowed the client the Scope
+	ConsentedBy string
+}
+
+// AuthorizationRequestStore keeps authorization requests in memory, a restart means the user has to
+// start over at the client
+type AuthorizationRequestStore struct {
+	mu       *sync.Mutex
+	requests map[string]*AuthorizationRequest
+}
+
+func NewAuthorizationRequestStore() *AuthorizationRequestStore {
+	return &AuthorizationRequestStore{
+		mu:       &sync.Mutex{},
+		requests: make(map[string]*AuthorizationRequest),
+	}
+}
+
+// Add stores a copy of request under a new random Id and returns the Id
+func (s *AuthorizationRequestStore) Add(request AuthorizationRequest) (string, error) {
+	id, err := generateASCII(32)
+	if err != nil {
+		return "", err
+	}
+	request.Id = id
+	request.Exp = time.Now().Add(AuthorizationRequestExpiration).Unix()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[id] = &request
+	return id, nil
+}
+
+// Get returns a copy of the request, changes to it have to go through the store's methods
+func (s *AuthorizationRequestStore) Get(id string) (AuthorizationRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	request, err := s.get(id)
+	if err != nil {
+		return AuthorizationRequest{}, err
+	}
+	return *request, nil
+}
+
+// Authenticate records who logged in for the request
+func (s *AuthorizationRequestStore) Authenticate(id, subject string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	request, err := s.get(id)
+	if err != nil {
+		return err
+	}
+	request.Subject = subject
+	return nil
+}
+
+// Consent records that subject allowed the request
+func (s *AuthorizationRequestStore) Consent(id, subject string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	request, err := s.get(id)
+	if err != nil {
+		return err
+	}
+	request.ConsentedBy = subject
+	return nil
+}
+
+// Take removes the request and returns it, a request can only be finished once
+func (s *AuthorizationRequestStore) Take(id string) (AuthorizationRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	request, err := s.get(id)
+	if err != nil {
+		return AuthorizationRequest{}, err
+	}
+	delete(s.requests, id)
+	return *request, nil
+}
+
+// Prune removes the expired requests
+func (s *AuthorizationRequestStore) Prune() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().Unix()
+	for id, request := range s.requests {
+		if now >= request.Exp {
+			delete(s.requests, id)
+		}
+	}
+}
+
+// StartPruning prunes the store every interval until ctx is done
+func (s *AuthorizationRequestStore) StartPruning(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Prune()
+		}
+	}
+}
+
+func (s *AuthorizationRequestStore) get(id string) (*AuthorizationRequest, error) {
+	request, ok := s.requests[id]
+	if !ok || time.Now().Unix() >= request.Exp {
+		return nil, ErrAuthorizationRequestNotFound
+	}
+	return request, nil
+}
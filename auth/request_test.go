@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestAuthorizationRequestStore(t *testing.T) {
+	s := NewAuthorizationRequestStore()
+	id, err := s.Add(AuthorizationRequest{ClientId: "client"})
+	assert.Nil(t, err)
+
+	assert.Nil(t, s.Authenticate(id, "jake"))
+	assert.Nil(t, s.Consent(id, "jake"))
+
+	request, err := s.Take(id)
+	assert.Nil(t, err)
+	assert.Equal(t, id, request.Id)
+	assert.Equal(t, "client", request.ClientId)
+	assert.Equal(t, "jake", request.Subject)
+	assert.Equal(t, "jake", request.ConsentedBy)
+
+	_, err = s.Take(id)
+	assert.ErrorIs(t, err, ErrAuthorizationRequestNotFound)
+}
+
+func TestAuthorizationRequestStore_Get_ReturnsCopy(t *testing.T) {
+	s := NewAuthorizationRequestStore()
+	id, _ := s.Add(AuthorizationRequest{})
+
+	request, _ := s.Get(id)
+	request.Subject = "jake"
+
+	stored, _ := s.Get(id)
+	assert.Empty(t, stored.Subject)
+}
+
+func TestAuthorizationRequestStore_Expired(t *testing.T) {
+	s := NewAuthorizationRequestStore()
+	original := AuthorizationRequestExpiration
+	AuthorizationRequestExpiration = -time.Second
+	defer func() { AuthorizationRequestExpiration = original }()
+	id, _ := s.Add(AuthorizationRequest{})
+
+	_, err := s.Get(id)
+	assert.ErrorIs(t, err, ErrAuthorizationRequestNotFound)
+	assert.ErrorIs(t, s.Authenticate(id, "jake"), ErrAuthorizationRequestNotFound)
+
+	s.Prune()
+	assert.Empty(t, s.requests)
+}
@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RevokedTokens is checked whenever an access token is validated, access tokens are stateless
+// JWTs so the only way to revoke one before it expires is to deny its jti
+var RevokedTokens TokenDenylist = NewDenylist()
+
+// TokenDenylist records revoked access tokens, Denylist is the in-memory implementation.
+// IsRevoked can't return an error, an implementation that fails to check should say the token is revoked
+type TokenDenylist interface {
+	Track(grantId, jti string, exp int64) error
+	Revoke(jti string, exp int64) error
+	RevokeGrant(grantId string) error
+	IsRevoked(jti string) bool
+}
+
+// Denylist keeps the jti of revoked access tokens until they would have expired anyway.
+// It also remembers which access tokens were issued for a grant, so all of them can be
+// revoked when the grant is (e.g. an authorization code replay, RFC 6749 section 4.1.2)
+type Denylist struct {
+	mu *sync.Mutex
+	// revoked maps a jti to the token's exp
+	revoked map[string]int64
+	grants  map[string][]issuedToken
+}
+
+type issuedToken struct {
+	jti string
+	exp int64
+}
+
+func NewDenylist() *Denylist {
+	return &Denylist{
+		mu:      &sync.Mutex{},
+		revoked: make(map[string]int64),
+		grants:  make(map[string][]issuedToken),
+	}
+}
+
+// Track records that the access token jti was issued for grantId
+func (d *Denylist) Track(grantId, jti string, exp int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.grants[grantId] = append(d.grants[grantId], issuedToken{jti: jti, exp: exp})
+	return nil
+}
+
+// Revoke denies jti until exp
+func (d *Denylist) Revoke(jti string, exp int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.prune(time.Now().Unix())
+	d.revoked[jti] = exp
+	return nil
+}
+
+// RevokeGrant denies every access token tracked for grantId
+func (d *Denylist) RevokeGrant(grantId string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.prune(time.Now().Unix())
+	for _, t := range d.grants[grantId] {
+		d.revoked[t.jti] = t.exp
+	}
+	delete(d.grants, grantId)
+	return nil
+}
+
+// IsRevoked checks if jti has been revoked
+func (d *Denylist) IsRevoked(jti string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.revoked[jti]
+	return ok
+}
+
+// Prune drops the revoked and tracked tokens that have expired. Tokens are tracked for every grant whether
+// it's revoked or not, so this has to run on its own, see StartPruning
+func (d *Denylist) Prune() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.prune(time.Now().Unix())
+}
+
+// StartPruning prunes the denylist every interval until ctx is done
+func (d *Denylist) StartPruning(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.Prune()
+		}
+	}
+}
+
+// prune drops everything that has expired, an expired token fails validation without the denylist
+func (d *Denylist) prune(now int64) {
+	for jti, exp := range d.revoked {
+		if exp < now {
+			delete(d.revoked, jti)
+		}
+	}
+	for grantId, tokens := range d.grants {
+		live := tokens[:0]
+		for _, t := range tokens {
+			if t.exp >= now {
+				live = append(live, t)
+			}
+		}
+		if len(live) == 0 {
+			delete(d.grants, grantId)
+		} else {
+			d.grants[grantId] = live
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestDenylist_Revoke(t *testing.T) {
+	d := NewDenylist()
+
+	d.Revoke("jti", time.Now().Add(time.Minute).Unix())
+
+	assert.True(t, d.IsRevoked("jti"))
+	assert.False(t, d.IsRevoked("other"))
+}
+
+func TestDenylist_RevokeGrant(t *testing.T) {
+	d := NewDenylist()
+	exp := time.Now().Add(time.Minute).Unix()
+	d.Track("grant", "first", exp)
+	d.Track("grant", "second", exp)
+	d.Track("other-grant", "third", exp)
+
+	d.RevokeGrant("grant")
+
+	assert.True(t, d.IsRevoked("first"))
+	assert.True(t, d.IsRevoked("second"))
+	assert.False(t, d.IsRevoked("third"))
+}
+
+func TestDenylist_PrunesExpired(t *testing.T) {
+	d := NewDenylist()
+	d.Revoke("expired", time.Now().Add(-time.Minute).Unix())
+
+	d.Revoke("jti", time.Now().Add(time.Minute).Unix())
+
+	assert.False(t, d.IsRevoked("expired"))
+	assert.True(t, d.IsRevoked("jti"))
+}
+
+func TestDenylist_Prune(t *testing.T) {
+	d := NewDenylist()
+	expired, live := time.Now().Add(-time.Minute).Unix(), time.Now().Add(time.Minute).Unix()
+	d.Track("expired", "first", expired)
+	d.Track("live", "second", expired)
+	d.Track("live", "third", live)
+
+	d.Prune()
+
+	assert.NotContains(t, d.grants, "expired")
+	assert.Equal(t, []issuedToken{{jti: "third", exp: live}}, d.grants["live"])
+}
+
+func TestParseAccessToken_Revoked(t *testing.T) {
+	token, _ := CreateJWT(nil, "jakedanson", "client", Issuer)
+	claims := token.Claims.(Claims)
+
+	RevokedTokens.Revoke(claims.ID, claims.ExpiresAt.Unix())
+
+	_, err := ParseAccessToken(token.Raw, Issuer)
+	assert.ErrorIs(t, err, ErrTokenRevoked)
+	valid, err := ValidateToken(token.Raw)
+	assert.False(t, valid)
+	assert.ErrorIs(t, err, ErrTokenRevoked)
+}
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ScopeRegistry is every scope the server knows about and what it grants access to
+var ScopeRegistry = map[string]string{
+	"openid":     "Sign in with OpenID Connect",
+	"profile":    "Read your profile",
+	"email":      "Read your email address",
+	"api.read":   "Read data from the API",
+	"api.write":  "Write data to the API",
+	"admin.read": "Read the admin API",
+}
+
+var ErrInvalidScope = errors.New("invalid_scope")
+
+// SupportedScopes returns the names of every registered scope
+func SupportedScopes() []string {
+	scopes := make([]string, 0, len(ScopeRegistry))
+	for s := range ScopeRegistry {
+		scopes = append(scopes, s)
+	}
+	sort.Strings(scopes)
+	return scopes
+}
+
+// ParseScope splits a space delimited scope (RFC 6749 section 3.3) removing duplicates
+func ParseScope(scope string) []string {
+	seen := make(map[string]bool)
+	scopes := make([]string, 0)
+	for _, s := range strings.Fields(scope) {
+		if !seen[s] {
+			seen[s] = true
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+// GrantScope checks the requested scope is registered and within allowed, and returns the scope to grant.
+// When nothing is requested everything in allowed is granted, as the default scope for the client.
+// The returned error wraps ErrInvalidScope
+func GrantScope(requested string, allowed []string) (string, error) {
+	if strings.TrimSpace(requested) == "" {
+		return strings.Join(allowed, " "), nil
+	}
+
+	scopes := ParseScope(requested)
+	for _, s := range scopes {
+		if _, ok := ScopeRegistry[s]; !ok {
+			return "", fmt.Errorf("%w: scope `%s` is not registered", ErrInvalidScope, s)
+		}
+		if !containsScope(allowed, s) {
+			return "", fmt.Errorf("%w: scope `%s` is not allowed for this client", ErrInvalidScope, s)
+		}
+	}
+	return strings.Join(scopes, " "), nil
+}
+
+// NarrowScope is used when refreshing, the requested scope can only be a subset of what was
+// originally granted (RFC 6749 section 6). An empty request keeps the original scope
+func NarrowScope(requested, original string) (string, error) {
+	if strings.TrimSpace(requested) == "" {
+		return original, nil
+	}
+
+	return GrantScope(requested, ParseScope(original))
+}
+
+// SameScope compares two space delimited scopes ignoring order and duplicates
+func SameScope(a, b string) bool {
+	as, bs := ParseScope(a), ParseScope(b)
+	if len(as) != len(bs) {
+		return false
+	}
+	for _, s := range as {
+		if !containsScope(bs, s) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsScope(scopes []string, s string) bool {
+	for _, v := range scopes {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
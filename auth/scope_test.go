@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestGrantScope(t *testing.T) {
+	allowed := []string{"openid", "profile", "api.read"}
+
+	granted, err := GrantScope("openid  profile openid", allowed)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "openid profile", granted)
+}
+
+func TestGrantScope_Default(t *testing.T) {
+	granted, err := GrantScope("", []string{"openid", "api.read"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "openid api.read", granted)
+}
+
+func TestGrantScope_NotAllowed(t *testing.T) {
+	_, err := GrantScope("openid api.write", []string{"openid", "api.read"})
+
+	assert.ErrorIs(t, err, ErrInvalidScope)
+}
+
+func TestGrantScope_NotRegistered(t *testing.T) {
+	_, err := GrantScope("admin", []string{"admin"})
+
+	assert.ErrorIs(t, err, ErrInvalidScope)
+}
+
+func TestNarrowScope(t *testing.T) {
+	narrowed, err := NarrowScope("api.read", "openid api.read")
+	assert.Nil(t, err)
+	assert.Equal(t, "api.read", narrowed)
+
+	unchanged, err := NarrowScope("", "openid api.read")
+	assert.Nil(t, err)
+	assert.Equal(t, "openid api.read", unchanged)
+
+	_, err = NarrowScope("api.write", "openid api.read")
+	assert.ErrorIs(t, err, ErrInvalidScope)
+}
+
+func TestSameScope(t *testing.T) {
+	assert.True(t, SameScope("openid api.read", "api.read openid"))
+	assert.False(t, SameScope("openid", "openid api.read"))
+	assert.True(t, SameScope("", ""))
+}
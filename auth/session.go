@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// SessionIdleTimeout ends a session that hasn't been used for this long
+	SessionIdleTimeout = 30 * time.Minute
+	// SessionAbsoluteTimeout ends a session this long after the user logged in, no matter how much it's used
+	SessionAbsoluteTimeout = 12 * time.Hour
+
+	ErrSessionNotFound = errors.New("session not found or expired")
+)
+
+// Session is a logged-in user. The id is only ever in the session cookie, stores keep its hash like they
+// do for codes and refresh tokens
+type Session struct {
+	// Id is only set on the session returned by NewSession, stores only keep IdHash (see HashToken)
+	Id      string
+	IdHash  string
+	Subject string
+	// AuthTime is when the user logged in, Exp is SessionAbsoluteTimeout after it
+	AuthTime int64
+	Exp      int64
+	// IdleExp is when the session ends unless it's used, every use moves it SessionIdleTimeout ahead
+	IdleExp int64
+}
+
+func NewSession(subject string) (*Session, error) {
+	id, err := generateASCII(43)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &Session{
+		Id:       id,
+		IdHash:   HashToken(id),
+		Subject:  subject,
+		AuthTime: now.Unix(),
+		Exp:      now.Add(SessionAbsoluteTimeout).Unix(),
+		IdleExp:  now.Add(SessionIdleTimeout).Unix(),
+	}, nil
+}
+
+// Active checks if neither the idle nor the absolute timeout has passed at now
+func (s Session) Active(now time.Time) bool {
+	return now.Unix() < s.Exp && now.Unix() < s.IdleExp
+}
+
+// SessionStore keeps sessions in memory, keyed by the hash of their id
+type SessionStore struct {
+	mu       *sync.Mutex
+	sessions map[string]*Session
+}
+
+func NewSessionStore() *SessionStore {
+	return &SessionStore{
+		mu:       &sync.Mutex{},
+		sessions: make(map[string]*Session),
+	}
+}
+
+// Create starts a session for subject, the returned session is the only one with the Id set
+func (ss *SessionStore) Create(subject string) (*Session, error) {
+	s, err := NewSession(subject)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := *s
+	stored.Id = ""
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.sessions[s.IdHash] = &stored
+	return s, nil
+}
+
+// Get returns the session with id and counts as a use of it, so its idle timeout starts over
+func (ss *SessionStore) Get(id string) (Session, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	hash := HashToken(id)
+	s, ok := ss.sessions[hash]
+	if !ok {
+		return Session{}, ErrSessionNotFound
+	}
+	now := time.Now()
+	if !s.Active(now) {
+		delete(ss.sessions, hash)
+		return Session{}, ErrSessionNotFound
+	}
+	s.IdleExp = now.Add(SessionIdleTimeout).Unix()
+	return *s, nil
+}
+
+// Delete ends the session, it's not an error if it has already ended
+func (ss *SessionStore) Delete(id string) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	delete(ss.sessions, HashToken(id))
+	return nil
+}
+
+// Prune removes the sessions that have timed out
+func (ss *SessionStore) Prune() {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	now := time.Now()
+	for hash, s := range ss.sessions {
+		if !s.Active(now) {
+			delete(ss.sessions, hash)
+		}
+	}
+}
+
+// StartPruning prunes the store every interval until ctx is done
+func (ss *SessionStore) StartPruning(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ss.Prune()
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSessionStore(t *testing.T) {
+	ss := NewSessionStore()
+	created, err := ss.Create("jake")
+	assert.Nil(t, err)
+	assert.NotEmpty(t, created.Id)
+	assert.Equal(t, created.AuthTime+int64(SessionAbsoluteTimeout.Seconds()), created.Exp)
+
+	// only the hash of the id is kept
+	stored, ok := ss.sessions[HashToken(created.Id)]
+	if assert.True(t, ok) {
+		assert.Empty(t, stored.Id)
+	}
+
+	s, err := ss.Get(created.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, "jake", s.Subject)
+
+	assert.Nil(t, ss.Delete(created.Id))
+	_, err = ss.Get(created.Id)
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+}
+
+func TestSessionStore_Get_ExtendsIdleTimeout(t *testing.T) {
+	ss := NewSessionStore()
+	created, _ := ss.Create("jake")
+	ss.sessions[created.IdHash].IdleExp = time.Now().Add(time.Second).Unix()
+
+	s, err := ss.Get(created.Id)
+
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, s.IdleExp, time.Now().Add(SessionIdleTimeout).Unix()-1)
+	assert.Equal(t, s.IdleExp, ss.sessions[created.IdHash].IdleExp)
+}
+
+func TestSessionStore_Timeouts(t *testing.T) {
+	var tests = []struct {
+		name    string
+		timeout *time.Duration
+	}{
+		{"idle", &SessionIdleTimeout},
+		{"absolute", &SessionAbsoluteTimeout},
+	}
+
+	for _, tt := range tests {
+		ss := NewSessionStore()
+		original := *tt.timeout
+		*tt.timeout = -time.Second
+		created, _ := ss.Create("jake")
+		*tt.timeout = original
+
+		_, err := ss.Get(created.Id)
+		assert.ErrorIsf(t, err, ErrSessionNotFound, "[%s] session didn't time out", tt.name)
+		assert.Emptyf(t, ss.sessions, "[%s] timed out session wasn't removed", tt.name)
+	}
+}
+
+func TestSessionStore_Prune(t *testing.T) {
+	ss := NewSessionStore()
+	original := SessionIdleTimeout
+	SessionIdleTimeout = -time.Second
+	idle, _ := ss.Create("jake")
+	SessionIdleTimeout = original
+	live, _ := ss.Create("jake")
+
+	ss.Prune()
+
+	assert.NotContains(t, ss.sessions, idle.IdHash)
+	assert.Contains(t, ss.sessions, live.IdHash)
+}
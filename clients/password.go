@@ -0,0 +1,132 @@
+package clients
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Argon2Params are the argon2id parameters new password hashes are made with. Hashes made with
+// different parameters still verify, but are rehashed the next time the user logs in
+type Argon2Params struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+// PasswordParams defaults to the OWASP password storage recommendation for argon2id
+var PasswordParams = Argon2Params{
+	Memory:  19 * 1024,
+	Time:    2,
+	Threads: 1,
+	SaltLen: 16,
+	KeyLen:  32,
+}
+
+var ErrUnsupportedHash = errors.New("password hash is not argon2id or bcrypt")
+
+// HashPassword hashes password with argon2id and PasswordParams, encoded as a PHC string:
+// $argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>
+func HashPassword(password string) (string, error) {
+	p := PasswordParams
+	salt := make([]byte, p.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// IsPasswordHash checks if hash is a PHC string VerifyPassword understands, anything else is a plaintext password
+func IsPasswordHash(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$") || isBcrypt(hash)
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// VerifyPassword checks password against an argon2id or bcrypt hash in constant time. needsRehash is true
+// when the password matched but the hash isn't argon2id with the current PasswordParams
+func VerifyPassword(hash, password string) (ok bool, needsRehash bool, err error) {
+	if isBcrypt(hash) {
+		err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		}
+		if err != nil {
+			return false, false, err
+		}
+		return true, true, nil
+	}
+
+	p, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return false, false, err
+	}
+
+	computed := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(computed, key) != 1 {
+		return false, false, nil
+	}
+
+	current := PasswordParams
+	needsRehash = p.Memory != current.Memory || p.Time != current.Time || p.Threads != current.Threads ||
+		p.SaltLen != current.SaltLen || p.KeyLen != current.KeyLen
+	return true, needsRehash, nil
+}
+
+func decodeArgon2id(hash string) (p Argon2Params, salt, key []byte, err error) {
+	// "", "argon2id", "v=19", "m=19456,t=2,p=1", salt, hash
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, nil, nil, ErrUnsupportedHash
+	}
+
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return p, nil, nil, fmt.Errorf("malformed argon2id version: %w", err)
+	}
+	if version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("unsupported argon2id version %d", version)
+	}
+
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return p, nil, nil, fmt.Errorf("malformed argon2id parameters: %w", err)
+	}
+
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return p, nil, nil, fmt.Errorf("malformed argon2id salt: %w", err)
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return p, nil, nil, fmt.Errorf("malformed argon2id hash: %w", err)
+	}
+	p.SaltLen = uint32(len(salt))
+	p.KeyLen = uint32(len(key))
+	return p, salt, key, nil
+}
+
+var (
+	dummyHashOnce = &sync.Once{}
+	dummyHash     string
+)
+
+// VerifyDummyPassword takes as long as VerifyPassword, it's used when the user doesn't exist so
+// the response time doesn't tell if a username is registered
+func VerifyDummyPassword(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = HashPassword("dummy password")
+	})
+	_, _, _ = VerifyPassword(dummyHash, password)
+}
@@ -0,0 +1,102 @@
+package clients
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("helloworld")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=19456,t=2,p=1$"))
+
+	ok, needsRehash, err := VerifyPassword(hash, "helloworld")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.False(t, needsRehash)
+
+	ok, _, err = VerifyPassword(hash, "hello world")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	other, _ := HashPassword("helloworld")
+	assert.NotEqual(t, hash, other, "every hash gets its own salt")
+}
+
+func TestVerifyPassword_Bcrypt(t *testing.T) {
+	b, _ := bcrypt.GenerateFromPassword([]byte("helloworld"), bcrypt.MinCost)
+
+	ok, needsRehash, err := VerifyPassword(string(b), "helloworld")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.True(t, needsRehash, "bcrypt hashes are upgraded to argon2id")
+
+	ok, needsRehash, err = VerifyPassword(string(b), "wrong")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.False(t, needsRehash)
+}
+
+func TestVerifyPassword_ChangedParams(t *testing.T) {
+	hash, _ := HashPassword("helloworld")
+
+	original := PasswordParams
+	PasswordParams.Time = 3
+	defer func() { PasswordParams = original }()
+
+	ok, needsRehash, err := VerifyPassword(hash, "helloworld")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.True(t, needsRehash)
+}
+
+func TestVerifyPassword_Malformed(t *testing.T) {
+	for _, hash := range []string{"", "helloworld", "$argon2i$v=19$m=19456,t=2,p=1$c2FsdA$aGFzaA", "$argon2id$v=19$m=x$c2FsdA$aGFzaA"} {
+		ok, _, err := VerifyPassword(hash, "helloworld")
+		assert.NotNil(t, err, hash)
+		assert.False(t, ok, hash)
+	}
+}
+
+func TestOpenUserFile_HashesPlaintext(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "users.json")
+	assert.Nil(t, os.WriteFile(filename, []byte(`{"jake": {"password": "helloworld"}}`), 0600))
+
+	uf, err := OpenUserFile(filename)
+	assert.Nil(t, err)
+
+	user, err := uf.User("jake")
+	assert.Nil(t, err)
+	assert.Empty(t, user.Password)
+	ok, _, _ := VerifyPassword(user.PasswordHash, "helloworld")
+	assert.True(t, ok)
+
+	b, _ := os.ReadFile(filename)
+	assert.NotContains(t, string(b), "helloworld")
+	var saved Users
+	assert.Nil(t, json.Unmarshal(b, &saved))
+	assert.Equal(t, user.PasswordHash, saved["jake"].PasswordHash)
+
+	_, err = uf.User("nope")
+	assert.ErrorIs(t, err, ErrUserNotFound)
+}
+
+func TestUserFile_SetPasswordHash(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "users.json")
+	assert.Nil(t, os.WriteFile(filename, []byte(`{"jake": {"password": "helloworld"}}`), 0600))
+	uf, _ := OpenUserFile(filename)
+
+	hash, _ := HashPassword("new password")
+	assert.Nil(t, uf.SetPasswordHash("jake", hash))
+	assert.ErrorIs(t, uf.SetPasswordHash("nope", hash), ErrUserNotFound)
+
+	reopened, err := OpenUserFile(filename)
+	assert.Nil(t, err)
+	user, _ := reopened.User("jake")
+	assert.Equal(t, hash, user.PasswordHash)
+}
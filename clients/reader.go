@@ -2,49 +2,124 @@ package clients
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"os"
 )
 
+var (
+	ErrClientNotFound = errors.New("client not found")
+	ErrUserNotFound   = errors.New("user not found")
+)
+
 type Client struct {
-	Name         string `json:"name"`
-	Type         string `json:"type"`
-	Description  string `json:"description"`
-	ClientId     string `json:"client_id"`
-	ClientSecret string `json:"client_secret"`
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	ClientId    string `json:"client_id"`
+	// ClientSecrets are the secrets the client can authenticate with, any unexpired one works
+	ClientSecrets []ClientSecret `json:"client_secrets"`
+	// ClientSecret is a plaintext secret from before secrets were hashed, ReadClients replaces it with a hash
+	ClientSecret string   `json:"client_secret,omitempty"`
+	RedirectUris []string `json:"redirect_uris"`
+	// AllowedScopes are the scopes the client can request, see auth.ScopeRegistry
+	AllowedScopes []string `json:"allowed_scopes"`
+	// AllowedResources are the resource servers the client can ask access tokens for with the resource
+	// parameter (RFC 8707), tokens for this server's issuer are always allowed
+	AllowedResources []string `json:"allowed_resources"`
+	// ResourceServer lets the client introspect tokens issued to any client, other clients can only
+	// introspect their own (RFC 7662 section 4)
+	ResourceServer bool `json:"resource_server"`
+	// IdTokenSignedResponseAlg is the alg ID Tokens for this client are signed with, RS256 when empty
+	IdTokenSignedResponseAlg string `json:"id_token_signed_response_alg"`
 }
 
-// TODO: Combine these functions into one
-func ReadClients(filename string) map[string]Client {
-	b, err := os.ReadFile(filename)
-	if err != nil {
-		panic("Unable to read clients file, error: " + err.Error())
+// IdTokenAlg returns the alg to sign ID Tokens for this client with, see
+// https://openid.net/specs/openid-connect-registration-1_0.html#ClientMetadata
+func (c Client) IdTokenAlg() string {
+	if c.IdTokenSignedResponseAlg == "" {
+		return "RS256"
 	}
+	return c.IdTokenSignedResponseAlg
+}
 
-	clients := make(map[string]Client)
-	err = json.Unmarshal(b, &clients)
-	if err != nil {
-		panic("Failed to unmarshal data, error: " + err.Error())
+// CheckIdTokenAlg checks the client's ID Token alg is one of algs, the algs there is a signing key for
+// Clients are checked when they're loaded, otherwise a bad alg would only show once a code was redeemed
+func (c Client) CheckIdTokenAlg(algs []string) error {
+	for _, alg := range algs {
+		if alg == c.IdTokenAlg() {
+			return nil
+		}
 	}
+	return fmt.Errorf("client %s has id_token_signed_response_alg %s, but there is no signing key for it", c.ClientId, c.IdTokenAlg())
+}
 
-	return clients
+// HasRedirectUri does an exact match of uri against the client's registered redirect uris,
+// see RFC 6749 section 3.1.2.3
+func (c Client) HasRedirectUri(uri string) bool {
+	for _, r := range c.RedirectUris {
+		if r == uri {
+			return true
+		}
+	}
+	return false
 }
 
-type User struct {
-	Password string `json:"password"`
+// AllowsResource does an exact match of resource against the client's allowed resources
+func (c Client) AllowsResource(resource string) bool {
+	for _, r := range c.AllowedResources {
+		if r == resource {
+			return true
+		}
+	}
+	return false
+}
+
+// Clients is the in-memory client store, keyed by client id
+type Clients map[string]Client
+
+// Client looks up a registered client
+func (c Clients) Client(clientId string) (Client, error) {
+	client, ok := c[clientId]
+	if !ok {
+		return Client{}, ErrClientNotFound
+	}
+	return client, nil
 }
 
-// TODO: Combine these functions into one
-func ReadUsers(filename string) map[string]User {
+// ReadClients reads the clients file, it panics when the file can't be read or parsed
+func ReadClients(filename string) Clients {
 	b, err := os.ReadFile(filename)
 	if err != nil {
-		panic("Unable to read users file, error: " + err.Error())
+		panic("Unable to read clients file, error: " + err.Error())
 	}
 
-	clients := make(map[string]User)
+	clients := make(Clients)
 	err = json.Unmarshal(b, &clients)
 	if err != nil {
 		panic("Failed to unmarshal data, error: " + err.Error())
 	}
 
+	for clientId, client := range clients {
+		if client.ClientSecret == "" {
+			continue
+		}
+		log.Printf("warning: client %s has a plaintext client_secret in %s, replace it with its hash in client_secrets\n", clientId, filename)
+		client.ClientSecrets = append(client.ClientSecrets, ClientSecret{Hash: HashClientSecret(client.ClientSecret)})
+		client.ClientSecret = ""
+		clients[clientId] = client
+	}
+
 	return clients
 }
+
+type User struct {
+	// PasswordHash is an argon2id or bcrypt PHC string, see HashPassword
+	PasswordHash string `json:"password_hash,omitempty"`
+	// Password is a plaintext password from before passwords were hashed, OpenUserFile replaces it with a hash
+	Password string `json:"password,omitempty"`
+}
+
+// Users are the users in users.json, keyed by username
+type Users map[string]User
@@ -21,5 +21,28 @@ func TestReadClients(t *testing.T) {
 	if c["test_cc_grant"].Description != expectedDescription {
 		t.Errorf("Expected %v, Got %v", expectedDescription, c["test_cc_grant"].Description)
 	}
+	if !c["test_ac_grant"].HasRedirectUri("https://oauth.pstmn.io/v1/callback") {
+		t.Errorf("Expected redirect uri %v to be registered", "https://oauth.pstmn.io/v1/callback")
+	}
+	if c["test_ac_grant"].HasRedirectUri("https://oauth.pstmn.io/v1/callback/") {
+		t.Errorf("Expected redirect uri matching to be exact")
+	}
+	if !c["test_ac_grant"].AllowsResource("https://api.example.com") || c["test_ac_grant"].AllowsResource("https://api.example.com/") {
+		t.Errorf("Expected resource matching to be exact")
+	}
 	fmt.Println(uuid.New())
 }
+
+func TestClient_CheckIdTokenAlg(t *testing.T) {
+	algs := []string{"RS256", "ES256"}
+
+	if err := (Client{ClientId: "default"}).CheckIdTokenAlg(algs); err != nil {
+		t.Errorf("Expected the default alg to be accepted, Got %v", err)
+	}
+	if err := (Client{ClientId: "es", IdTokenSignedResponseAlg: "ES256"}).CheckIdTokenAlg(algs); err != nil {
+		t.Errorf("Expected ES256 to be accepted, Got %v", err)
+	}
+	if err := (Client{ClientId: "ed", IdTokenSignedResponseAlg: "EdDSA"}).CheckIdTokenAlg(algs); err == nil {
+		t.Errorf("Expected EdDSA to be rejected without a signing key for it")
+	}
+}
@@ -0,0 +1,54 @@
+package clients
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"time"
+)
+
+// ClientSecret is one of a client's secrets. A client can have several at once, so a new secret can be
+// handed out while the old one still works until it expires
+type ClientSecret struct {
+	// Hash is the hex encoded SHA-256 of the secret, see HashClientSecret
+	Hash string `json:"hash"`
+	// ExpiresAt is the unix time the secret stops working at, it never expires when 0
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+}
+
+// Expired checks if the secret has stopped working at now
+func (s ClientSecret) Expired(now time.Time) bool {
+	return s.ExpiresAt != 0 && now.Unix() >= s.ExpiresAt
+}
+
+// HashClientSecret hashes a client secret. Unlike passwords, secrets are long random strings so a plain
+// SHA-256 is enough, it's the same as `echo -n <secret> | sha256sum`
+func HashClientSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Confidential checks if the client has secrets to authenticate with, a public client has none
+// (RFC 6749 section 2.1)
+func (c Client) Confidential() bool {
+	return len(c.ClientSecrets) > 0
+}
+
+// VerifySecret checks secret against every unexpired secret of the client. All of them are compared in
+// constant time, so the response time doesn't tell which secret, if any, was close
+func (c Client) VerifySecret(secret string) bool {
+	sum := sha256.Sum256([]byte(secret))
+	now := time.Now()
+
+	match := 0
+	for _, s := range c.ClientSecrets {
+		hash, err := hex.DecodeString(s.Hash)
+		if err != nil {
+			continue
+		}
+		if subtle.ConstantTimeCompare(sum[:], hash) == 1 && !s.Expired(now) {
+			match = 1
+		}
+	}
+	return match == 1
+}
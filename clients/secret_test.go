@@ -0,0 +1,40 @@
+package clients
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestHashClientSecret(t *testing.T) {
+	// echo -n secret | sha256sum
+	assert.Equal(t, "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b", HashClientSecret("secret"))
+}
+
+func TestClient_VerifySecret(t *testing.T) {
+	c := Client{ClientSecrets: []ClientSecret{
+		{Hash: HashClientSecret("old"), ExpiresAt: time.Now().Add(time.Hour).Unix()},
+		{Hash: HashClientSecret("new")},
+	}}
+
+	assert.True(t, c.VerifySecret("old"), "both secrets work while the old one is being rotated out")
+	assert.True(t, c.VerifySecret("new"))
+	assert.False(t, c.VerifySecret("wrong"))
+	assert.False(t, c.VerifySecret(""))
+
+	c.ClientSecrets[0].ExpiresAt = time.Now().Add(-time.Second).Unix()
+	assert.False(t, c.VerifySecret("old"))
+	assert.True(t, c.VerifySecret("new"))
+}
+
+func TestClient_VerifySecret_NoSecrets(t *testing.T) {
+	assert.False(t, Client{}.VerifySecret(""))
+	assert.False(t, Client{ClientSecrets: []ClientSecret{{Hash: "not hex"}}}.VerifySecret("not hex"))
+}
+
+func TestReadClients_HashesPlaintextSecrets(t *testing.T) {
+	c := ReadClients("clients_test.json")["test_cc_grant"]
+
+	assert.Empty(t, c.ClientSecret)
+	assert.True(t, c.VerifySecret("ddccd2d0-2b80-4ee8-ab33-beaa5fcb89fe"))
+}
@@ -0,0 +1,109 @@
+package clients
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+)
+
+// UserFile is the in-memory user store. It's read from a users.json file and password changes
+// are written back to it
+type UserFile struct {
+	mu       *sync.RWMutex
+	filename string
+	users    Users
+}
+
+// OpenUserFile reads the users in filename. Plaintext passwords are hashed and the file is
+// rewritten without them, so no plaintext password stays on disk
+func OpenUserFile(filename string) (*UserFile, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make(Users)
+	if err = json.Unmarshal(b, &users); err != nil {
+		return nil, err
+	}
+
+	uf := &UserFile{
+		mu:       &sync.RWMutex{},
+		filename: filename,
+		users:    users,
+	}
+
+	migrated := 0
+	for username, user := range users {
+		if user.Password == "" {
+			continue
+		}
+		if user.PasswordHash, err = HashPassword(user.Password); err != nil {
+			return nil, err
+		}
+		user.Password = ""
+		users[username] = user
+		migrated++
+	}
+	if migrated > 0 {
+		if err = uf.save(); err != nil {
+			return nil, err
+		}
+		log.Printf("hashed %d plaintext passwords in %s\n", migrated, filename)
+	}
+
+	return uf, nil
+}
+
+// User looks up a user by username
+func (uf *UserFile) User(username string) (User, error) {
+	uf.mu.RLock()
+	defer uf.mu.RUnlock()
+
+	user, ok := uf.users[username]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// SetPasswordHash replaces the user's password hash and saves the file
+func (uf *UserFile) SetPasswordHash(username, hash string) error {
+	uf.mu.Lock()
+	defer uf.mu.Unlock()
+
+	user, ok := uf.users[username]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user.PasswordHash = hash
+	uf.users[username] = user
+	return uf.save()
+}
+
+// Users returns a copy of every user, to import them into another store
+func (uf *UserFile) Users() Users {
+	uf.mu.RLock()
+	defer uf.mu.RUnlock()
+
+	users := make(Users, len(uf.users))
+	for username, user := range uf.users {
+		users[username] = user
+	}
+	return users
+}
+
+// save writes the users to a temporary file first so a crash can't leave users.json half written
+func (uf *UserFile) save() error {
+	b, err := json.MarshalIndent(uf.users, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := uf.filename + ".tmp"
+	if err = os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, uf.filename)
+}
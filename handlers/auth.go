@@ -2,19 +2,26 @@ package handlers
 
 import (
 	"JakeOAuth/auth"
+	"JakeOAuth/storage"
 )
 
 type AuthHandler struct {
-	CodeStore *auth.AuthorizationCodeStore
+	CodeStore    storage.CodeStore
+	TokenStore   storage.TokenStore
+	ClientStore  storage.ClientStore
+	UserStore    storage.UserStore
+	SessionStore storage.SessionStore
+	// Requests are the authorization requests waiting for the user to log in and consent
+	Requests *auth.AuthorizationRequestStore
 }
 
-func NewAuthHandler(acs *auth.AuthorizationCodeStore) *AuthHandler {
+func NewAuthHandler(codes storage.CodeStore, tokens storage.TokenStore, registeredClients storage.ClientStore, users storage.UserStore, sessions storage.SessionStore) *AuthHandler {
 	return &AuthHandler{
-		CodeStore: acs,
+		CodeStore:    codes,
+		TokenStore:   tokens,
+		ClientStore:  registeredClients,
+		UserStore:    users,
+		SessionStore: sessions,
+		Requests:     auth.NewAuthorizationRequestStore(),
 	}
 }
-
-// RedirectionEndpointHandler used by the authorization server to return
-// responses containing authorization credentials to the client via the
-// resource owner user-agent
-func RedirectionEndpointHandler() {}
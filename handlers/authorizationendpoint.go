@@ -2,12 +2,14 @@ package handlers
 
 import (
 	"JakeOAuth/auth"
-	"encoding/json"
+	"JakeOAuth/clients"
 	"errors"
-	"fmt"
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // AuthorizationEndpointHandler used by the client to obtain
@@ -19,75 +21,267 @@ func (h *AuthHandler) AuthorizationEndpointHandler(w http.ResponseWriter, req *h
 	err := req.ParseForm()
 	if err != nil {
 		log.Println("error: AuthorizationEndpointHandler, failed to parse form")
-		w.Write([]byte("unauthorized_client:The authorization server encountered an unexpected condition that prevented it from fulfilling the request."))
+		writeOAuthError(w, errInvalidRequest("The request could not be parsed."), authorizationErrorUri)
 		log.Println(err)
 		return
 	}
 
 	formVals := req.Form
 
+	// the user is coming back from the login and consent pages
+	if formVals.Has("request_id") {
+		h.resumeAuthorizationRequest(w, req, formVals.Get("request_id"))
+		return
+	}
+
 	state := ""
 	if formVals.Has("state") {
 		state = formVals.Get("state")
 	}
 
-	//TODO check if client id is in list of clients
+	// the client and redirect uri have to be verified before anything is sent back to the redirect uri,
+	// otherwise this would be an open redirector (RFC 6749 section 4.1.2.1)
+	client, err := h.ClientStore.Client(formVals.Get("client_id"))
+	if errors.Is(err, clients.ErrClientNotFound) {
+		writeOAuthError(w, errInvalidRequest("The client_id is missing or is not a registered client."), authorizationErrorUri)
+		log.Printf("error: client id is not registered: `%s`\n", formVals.Get("client_id"))
+		return
+	}
+	if err != nil {
+		writeOAuthError(w, errServerError("The client could not be looked up."), authorizationErrorUri)
+		log.Println("error: failed to look up client:", err)
+		return
+	}
+
+	redirectUri, err := validateRedirectUri(client, formVals.Get("redirect_uri"))
+	if err != nil {
+		writeOAuthError(w, errInvalidRequest("The redirect_uri is missing or does not match a registered redirect uri."), authorizationErrorUri)
+		log.Println("error:", err)
+		return
+	}
+
+	if client.Type != "authorization_code" {
+		redirectWithError(w, req, redirectUri, errUnauthorizedClient("The client is not authorized to request an authorization code using this method."), state)
+		log.Printf("error: client %s is not an authorization_code client\n", client.ClientId)
+		return
+	}
+
+	scope, err := auth.GrantScope(formVals.Get("scope"), client.AllowedScopes)
+	if err != nil {
+		redirectWithError(w, req, redirectUri, errInvalidScope("The requested scope is invalid, unknown, or malformed."), state)
+		log.Println("error:", err)
+		return
+	}
+
 	responseType := formVals.Get("response_type")
 	switch responseType {
 	case "code":
-		h.authCodeGrantRequirementsFlow(formVals, w, req, state)
+		h.authCodeGrantRequirementsFlow(formVals, w, req, redirectUri, state, scope)
 	default:
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("invalid_request:The request is missing a required parameter,includes an invalid parameter value, includes a parameter more than once, or is otherwise malformed."))
+		redirectWithError(w, req, redirectUri, errUnsupportedResponseType("The authorization server does not support obtaining an authorization code using this method."), state)
 		log.Printf("error: this request type is not supported: `%s`\n", responseType)
 	}
 
 	return
 }
 
-func (h *AuthHandler) authCodeGrantRequirementsFlow(formVals url.Values, w http.ResponseWriter, req *http.Request, state string) {
-	// need to implement scopes, and state. probably no redirect uri, that seems weird
-	if !formVals.Has("response_type") && !formVals.Has("client_id") && !formVals.Has("code_challenge") {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("invalid_request:The request is missing a required parameter,includes an invalid parameter value, includes a parameter more than once, or is otherwise malformed."))
-		log.Println("error: response_type or client_id missing from client request")
+func (h *AuthHandler) authCodeGrantRequirementsFlow(formVals url.Values, w http.ResponseWriter, req *http.Request, redirectUri, state, scope string) {
+	if err := requiredFormVals(formVals, "code_challenge"); err != nil {
+		redirectWithError(w, req, redirectUri, errInvalidRequest("The request is missing the required code_challenge parameter."), state)
+		log.Println("error:", err)
 		return
 	}
 	codeChallengeMethod := ""
 	if formVals.Has("code_challenge_method") {
-		switch formVals.Get("code_challenge_method") {
-		case "plain":
-			codeChallengeMethod = "plain"
-		case "S256":
-			codeChallengeMethod = "S256"
+		codeChallengeMethod = formVals.Get("code_challenge_method")
+		if !contains(codeChallengeMethodsSupported, codeChallengeMethod) {
+			redirectWithError(w, req, redirectUri, errInvalidRequest("The code_challenge_method is not supported."), state)
+			log.Printf("error: code challenge method is not supported: `%s`\n", codeChallengeMethod)
+			return
+		}
+	}
+
+	prompt := formVals.Get("prompt")
+	if err := validatePrompt(prompt); err != nil {
+		redirectWithError(w, req, redirectUri, errInvalidRequest("The prompt parameter is invalid or not supported."), state)
+		log.Println("error:", err)
+		return
+	}
+	maxAge := int64(-1)
+	if formVals.Has("max_age") {
+		var err error
+		maxAge, err = strconv.ParseInt(formVals.Get("max_age"), 10, 64)
+		if err != nil || maxAge < 0 {
+			redirectWithError(w, req, redirectUri, errInvalidRequest("The max_age parameter has to be a number of seconds."), state)
+			log.Printf("error: invalid max_age: `%s`\n", formVals.Get("max_age"))
+			return
+		}
+	}
+
+	// consent is never remembered, so prompt=none can't be done without showing a page (OpenID Connect
+	// Core section 3.1.2.6)
+	if hasScope(prompt, "none") {
+		if _, ok := h.currentSession(req); !ok {
+			redirectWithError(w, req, redirectUri, errLoginRequired("The user has to log in."), state)
+		} else {
+			redirectWithError(w, req, redirectUri, errConsentRequired("The user has to allow the client."), state)
 		}
+		return
+	}
+
+	// the request is kept on the server while the user logs in and consents, the pages only get its id
+	requestId, err := h.Requests.Add(auth.AuthorizationRequest{
+		ClientId:             formVals.Get("client_id"),
+		RedirectUri:          redirectUri,
+		RequestedRedirectUri: formVals.Get("redirect_uri"),
+		State:                state,
+		Scope:                scope,
+		RequestedScope:       formVals.Get("scope"),
+		CodeChallenge:        formVals.Get("code_challenge"),
+		CodeChallengeMethod:  codeChallengeMethod,
+		Nonce:                formVals.Get("nonce"),
+		Prompt:               prompt,
+		MaxAge:               maxAge,
+	})
+	if err != nil {
+		redirectWithError(w, req, redirectUri, errServerError("The authorization request could not be stored."), state)
+		log.Println("error: failed to store authorization request:", err)
+		return
+	}
+
+	h.resumeAuthorizationRequest(w, req, requestId)
+}
+
+// resumeAuthorizationRequest sends the user to whichever of the login and consent pages they haven't
+// been through yet, and issues the authorization code for the session's user once they have been through both
+func (h *AuthHandler) resumeAuthorizationRequest(w http.ResponseWriter, req *http.Request, requestId string) {
+	request, err := h.Requests.Get(requestId)
+	if err != nil {
+		// without the request there is no redirect uri that's known to be safe to send the error to
+		writeOAuthError(w, errInvalidRequest("The authorization request is unknown or has expired."), authorizationErrorUri)
+		log.Println("error:", err)
+		return
+	}
+
+	params := url.Values{"request_id": {requestId}}
+	session, ok := h.currentSession(req)
+	if !ok || needsLogin(request, session, time.Now()) {
+		http.Redirect(w, req, LoginPath+"?"+params.Encode(), http.StatusFound)
+		return
+	}
+	if request.ConsentedBy != session.Subject {
+		http.Redirect(w, req, ConsentPath+"?"+params.Encode(), http.StatusFound)
+		return
+	}
+
+	// taking the request makes sure only one code is issued for it
+	request, err = h.Requests.Take(requestId)
+	if err != nil {
+		writeOAuthError(w, errInvalidRequest("The authorization request is unknown or has expired."), authorizationErrorUri)
+		log.Println("error:", err)
+		return
+	}
+
+	code := auth.NewAuthorizationCode(request.CodeChallenge, request.CodeChallengeMethod, request.State)
+	code.ClientId = request.ClientId
+	code.RedirectUri = request.RequestedRedirectUri
+	code.Scope = request.Scope
+	code.RequestedScope = request.RequestedScope
+	code.Nonce = request.Nonce
+	code.Subject = session.Subject
+	code.AuthTime = session.AuthTime
+	if err = h.CodeStore.Add(code); err != nil {
+		redirectWithError(w, req, request.RedirectUri, errServerError("The authorization code could not be stored."), request.State)
+		log.Println("error: failed to store authorization code:", err)
+		return
+	}
+
+	params = url.Values{}
+	params.Set("code", code.Code)
+	if request.State != "" {
+		params.Set("state", request.State)
+	}
+	redirectWithParams(w, req, request.RedirectUri, params)
+}
+
+// needsLogin checks if the user has to log in again even though they have a session. prompt=login asks
+// for a login during this request, and max_age for one that isn't older than max_age seconds
+func needsLogin(request auth.AuthorizationRequest, session auth.Session, now time.Time) bool {
+	// someone else logged in on this browser since the login for this request
+	if request.Subject != "" && request.Subject != session.Subject {
+		return true
+	}
+	if hasScope(request.Prompt, "login") && request.Subject == "" {
+		return true
 	}
+	return request.MaxAge >= 0 && now.Unix()-session.AuthTime > request.MaxAge
+}
 
-	code := auth.NewAuthorizationCode(formVals.Get("code_challenge"), codeChallengeMethod, state)
-	h.CodeStore.Add(code)
-	url := fmt.Sprintf("https://oauth.pstmn.io/v1/callback?code=%s&state=%s&client_id=%s&grant_type=authorization_code", code.Code, state, formVals.Get("client_id"))
-	//http.Redirect(w, req, url, http.StatusFound)
+// validatePrompt checks the prompt parameter only has values that are supported, and that none is on its own
+func validatePrompt(prompt string) error {
+	values := strings.Fields(prompt)
+	for _, p := range values {
+		if !contains(promptValuesSupported, p) {
+			return errors.New("prompt value " + p + " is not supported")
+		}
+	}
+	if contains(values, "none") && len(values) > 1 {
+		return errors.New("prompt none can't be combined with other values")
+	}
+	return nil
+}
 
-	type redirect struct {
-		Url string `json:"url"`
+// validateRedirectUri returns the redirect uri to send the response to. The redirect_uri parameter
+// can only be left out when the client has exactly one registered redirect uri (RFC 6749 section 3.1.2.3)
+func validateRedirectUri(client clients.Client, redirectUri string) (string, error) {
+	if redirectUri == "" {
+		if len(client.RedirectUris) != 1 {
+			return "", errors.New("redirect_uri is required when a client does not have exactly one registered redirect uri")
+		}
+		return client.RedirectUris[0], nil
 	}
-	r := &redirect{
-		Url: url,
+
+	if !client.HasRedirectUri(redirectUri) {
+		return "", errors.New("redirect_uri " + redirectUri + " is not registered for client " + client.ClientId)
 	}
-	bytes, err := json.Marshal(r)
+	return redirectUri, nil
+}
 
+// redirectWithParams sends a 302 Found to redirectUri with params added to its query,
+// keeping any query the registered redirect uri already has
+func redirectWithParams(w http.ResponseWriter, req *http.Request, redirectUri string, params url.Values) {
+	u, err := url.Parse(redirectUri)
 	if err != nil {
-		panic(err)
+		writeOAuthError(w, errInvalidRequest("The redirect_uri could not be parsed."), authorizationErrorUri)
+		log.Printf("error: failed to parse redirect uri %s: %v\n", redirectUri, err)
+		return
+	}
+
+	q := u.Query()
+	for k := range params {
+		q.Set(k, params.Get(k))
+	}
+	u.RawQuery = q.Encode()
+	http.Redirect(w, req, u.String(), http.StatusFound)
+}
+
+// redirectWithError delivers an authorization endpoint error to the client's redirect uri (RFC 6749 section 4.1.2.1)
+func redirectWithError(w http.ResponseWriter, req *http.Request, redirectUri string, oauthErr *OAuthError, state string) {
+	params := url.Values{}
+	params.Set("error", oauthErr.Code)
+	params.Set("error_description", oauthErr.Description)
+	params.Set("error_uri", authorizationErrorUri)
+	if state != "" {
+		params.Set("state", state)
 	}
-	w.Write(bytes)
-	//_, _ = http.Get(url)
+	redirectWithParams(w, req, redirectUri, params)
 }
 
 func requiredFormVals(formVal url.Values, reqs ...string) error {
 	for _, r := range reqs {
 
 		if !formVal.Has(r) {
-			return errors.New("requiredFormValue " + r + "not present") // maybe map custom errors to each thingy thang here?
+			return errors.New("requiredFormValue " + r + " not present") // maybe map custom errors to each thingy thang here?
 		}
 	}
 	return nil
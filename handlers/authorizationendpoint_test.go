@@ -0,0 +1,330 @@
+package handlers
+
+import (
+	"JakeOAuth/auth"
+	"JakeOAuth/clients"
+	"JakeOAuth/storage"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func (b *testBrowser) authorize(h *AuthHandler, params url.Values) *httptest.ResponseRecorder {
+	return b.do(h.AuthorizationEndpointHandler, httptest.NewRequest(http.MethodGet, AuthorizationEndpointPath+"?"+params.Encode(), nil))
+}
+
+// redirectQuery checks w is a redirect and returns the query of where it redirects to
+func redirectQuery(t *testing.T, w *httptest.ResponseRecorder, path string) url.Values {
+	assert.Contains(t, []int{http.StatusFound, http.StatusSeeOther}, w.Code)
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	where := location.Path
+	if location.IsAbs() {
+		where = location.Scheme + "://" + location.Host + location.Path
+	}
+	assert.Equal(t, path, where, "wrong redirect")
+	return location.Query()
+}
+
+func (b *testBrowser) consent(t *testing.T, h *AuthHandler, requestId, decision string) *httptest.ResponseRecorder {
+	token := b.loadPage(t, h.HandleConsent, ConsentPath, requestId)
+	form := url.Values{"request_id": {requestId}, "csrf_token": {token}, "decision": {decision}}
+	return b.submitPage(h.HandleConsent, ConsentPath, form)
+}
+
+// withParams returns authorizeParams with extra added
+func withParams(extra url.Values) url.Values {
+	params := url.Values{}
+	for k, v := range authorizeParams {
+		params[k] = v
+	}
+	for k, v := range extra {
+		params[k] = v
+	}
+	return params
+}
+
+// authorizeWithSession runs an authorization request for a browser that's already logged in, up to the code
+func (b *testBrowser) authorizeWithSession(t *testing.T, h *AuthHandler, params url.Values) url.Values {
+	requestId := redirectQuery(t, b.authorize(h, params), ConsentPath).Get("request_id")
+	b.consent(t, h, requestId, "allow")
+	return redirectQuery(t, b.authorize(h, url.Values{"request_id": {requestId}}), "https://oauth.pstmn.io/v1/callback")
+}
+
+var authorizeParams = url.Values{
+	"response_type":         {"code"},
+	"client_id":             {acClientId},
+	"scope":                 {"openid api.read"},
+	"state":                 {"xyz"},
+	"code_challenge":        {"verifier"},
+	"code_challenge_method": {"plain"},
+}
+
+func TestAuthorizationEndpointHandler_LoginAndConsent(t *testing.T) {
+	h := newTestHandler()
+	b := newTestBrowser()
+
+	requestId := redirectQuery(t, b.authorize(h, authorizeParams), LoginPath).Get("request_id")
+	assert.NotEmpty(t, requestId)
+	resume := url.Values{"request_id": {requestId}}
+
+	// going straight back to the authorization endpoint doesn't skip logging in
+	redirectQuery(t, b.authorize(h, resume), LoginPath)
+
+	redirectQuery(t, b.login(t, h, requestId, "jake", "password"), AuthorizationEndpointPath)
+	redirectQuery(t, b.authorize(h, resume), ConsentPath)
+
+	redirectQuery(t, b.consent(t, h, requestId, "allow"), AuthorizationEndpointPath)
+	query := redirectQuery(t, b.authorize(h, resume), "https://oauth.pstmn.io/v1/callback")
+	assert.Equal(t, "xyz", query.Get("state"))
+
+	// the request is finished once the code is issued
+	assert.Equal(t, http.StatusBadRequest, b.authorize(h, resume).Code)
+
+	w := tokenRequest(h, url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {acClientId},
+		"client_secret": {acClientSecret},
+		"code":          {query.Get("code")},
+		"code_verifier": {"verifier"},
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp AccessTokenResponse
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	claims, err := auth.ParseAccessToken(resp.AccessToken, "")
+	if assert.Nil(t, err) {
+		assert.Equal(t, "jake", claims.Subject)
+		assert.Equal(t, "openid api.read", claims.Scope)
+	}
+}
+
+func TestAuthorizationEndpointHandler_RequiresSession(t *testing.T) {
+	h := newTestHandler()
+	b := newTestBrowser()
+	requestId := redirectQuery(t, b.authorize(h, authorizeParams), LoginPath).Get("request_id")
+	b.login(t, h, requestId, "jake", "password")
+	b.consent(t, h, requestId, "allow")
+
+	// another browser with the request id, but without the session, can't get the code
+	redirectQuery(t, newTestBrowser().authorize(h, url.Values{"request_id": {requestId}}), LoginPath)
+
+	// and neither can this browser once the session is gone
+	_ = h.SessionStore.Delete(b.cookies[sessionCookieName].Value)
+	redirectQuery(t, b.authorize(h, url.Values{"request_id": {requestId}}), LoginPath)
+}
+
+func TestAuthorizationEndpointHandler_ExistingSession(t *testing.T) {
+	h := newTestHandler()
+	b := newTestBrowser()
+	b.login(t, h, newTestRequest(t, h), "jake", "password")
+
+	query := b.authorizeWithSession(t, h, authorizeParams)
+
+	code, err := h.CodeStore.Redeem(query.Get("code"), "verifier", acClientId, "")
+	if assert.Nil(t, err) {
+		assert.Equal(t, "jake", code.Subject)
+		session, _ := h.SessionStore.Get(b.cookies[sessionCookieName].Value)
+		assert.Equal(t, session.AuthTime, code.AuthTime)
+	}
+}
+
+func TestAuthorizationEndpointHandler_PromptLogin(t *testing.T) {
+	h := newTestHandler()
+	b := newTestBrowser()
+	b.login(t, h, newTestRequest(t, h), "jake", "password")
+	first := b.cookies[sessionCookieName].Value
+
+	requestId := redirectQuery(t, b.authorize(h, withParams(url.Values{"prompt": {"login"}})), LoginPath).Get("request_id")
+	redirectQuery(t, b.login(t, h, requestId, "jake", "password"), AuthorizationEndpointPath)
+
+	redirectQuery(t, b.authorize(h, url.Values{"request_id": {requestId}}), ConsentPath)
+	assert.NotEqual(t, first, b.cookies[sessionCookieName].Value)
+}
+
+// agedSessions makes every session look like the user logged in age ago
+type agedSessions struct {
+	storage.SessionStore
+	age time.Duration
+}
+
+func (s agedSessions) Get(id string) (auth.Session, error) {
+	session, err := s.SessionStore.Get(id)
+	session.AuthTime -= int64(s.age.Seconds())
+	return session, err
+}
+
+func TestAuthorizationEndpointHandler_MaxAge(t *testing.T) {
+	h := newTestHandler()
+	b := newTestBrowser()
+	b.login(t, h, newTestRequest(t, h), "jake", "password")
+	h.SessionStore = agedSessions{h.SessionStore, 2 * time.Minute}
+
+	b.authorizeWithSession(t, h, withParams(url.Values{"max_age": {"3600"}}))
+
+	requestId := redirectQuery(t, b.authorize(h, withParams(url.Values{"max_age": {"60"}})), LoginPath).Get("request_id")
+	// logging in again starts a new session, which isn't older than max_age
+	h.SessionStore = h.SessionStore.(agedSessions).SessionStore
+	b.login(t, h, requestId, "jake", "password")
+	redirectQuery(t, b.authorize(h, url.Values{"request_id": {requestId}}), ConsentPath)
+}
+
+func TestAuthorizationEndpointHandler_PromptNone(t *testing.T) {
+	h := newTestHandler()
+	b := newTestBrowser()
+	params := withParams(url.Values{"prompt": {"none"}})
+
+	assert.Equal(t, "login_required", redirectQuery(t, b.authorize(h, params), "https://oauth.pstmn.io/v1/callback").Get("error"))
+
+	b.login(t, h, newTestRequest(t, h), "jake", "password")
+	assert.Equal(t, "consent_required", redirectQuery(t, b.authorize(h, params), "https://oauth.pstmn.io/v1/callback").Get("error"))
+}
+
+func TestAuthorizationEndpointHandler_Denied(t *testing.T) {
+	h := newTestHandler()
+	b := newTestBrowser()
+	requestId := redirectQuery(t, b.authorize(h, authorizeParams), LoginPath).Get("request_id")
+	b.login(t, h, requestId, "jake", "password")
+
+	query := redirectQuery(t, b.consent(t, h, requestId, "deny"), "https://oauth.pstmn.io/v1/callback")
+
+	assert.Equal(t, "access_denied", query.Get("error"))
+	assert.Equal(t, "xyz", query.Get("state"))
+	assert.Equal(t, http.StatusBadRequest, b.authorize(h, url.Values{"request_id": {requestId}}).Code)
+}
+
+func TestHandleConsent_RequiresSession(t *testing.T) {
+	h := newTestHandler()
+	requestId := newTestRequest(t, h)
+
+	w := newTestBrowser().do(h.HandleConsent, httptest.NewRequest(http.MethodGet, ConsentPath+"?request_id="+requestId, nil))
+
+	redirectQuery(t, w, LoginPath)
+}
+
+func TestHandleConsent_Page(t *testing.T) {
+	h := newTestHandler()
+	b := newTestBrowser()
+	requestId := newTestRequest(t, h)
+	b.login(t, h, requestId, "jake", "password")
+
+	w := b.do(h.HandleConsent, httptest.NewRequest(http.MethodGet, ConsentPath+"?request_id="+requestId, nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), auth.ScopeRegistry["api.read"])
+	assert.Contains(t, w.Body.String(), "jake_ac_grant")
+}
+
+func TestAuthorizationEndpointHandler_InvalidRequest(t *testing.T) {
+	h := newTestHandler()
+	var tests = []struct {
+		name   string
+		params url.Values
+	}{
+		{"missing code_challenge", withParams(url.Values{"code_challenge": {""}})},
+		{"unsupported prompt", withParams(url.Values{"prompt": {"select_account"}})},
+		{"prompt none with login", withParams(url.Values{"prompt": {"none login"}})},
+		{"negative max_age", withParams(url.Values{"max_age": {"-1"}})},
+		{"max_age not a number", withParams(url.Values{"max_age": {"an hour"}})},
+	}
+
+	for _, tt := range tests {
+		if tt.params.Get("code_challenge") == "" {
+			tt.params.Del("code_challenge")
+		}
+		query := redirectQuery(t, newTestBrowser().authorize(h, tt.params), "https://oauth.pstmn.io/v1/callback")
+
+		assert.Equalf(t, "invalid_request", query.Get("error"), "[%s] wrong error", tt.name)
+	}
+}
+
+// multiRedirectClients has a client with several redirect uris and one whose redirect uri has a query
+var multiRedirectClients = clients.Clients{
+	"multi": {
+		ClientId:      "multi",
+		Type:          "authorization_code",
+		RedirectUris:  []string{"https://a.example/cb", "https://b.example/cb"},
+		AllowedScopes: []string{"openid"},
+	},
+	"query": {
+		ClientId:      "query",
+		Type:          "authorization_code",
+		RedirectUris:  []string{"https://c.example/cb?tenant=jake"},
+		AllowedScopes: []string{"openid"},
+	},
+}
+
+func TestAuthorizationEndpointHandler_UnregisteredRedirectUri(t *testing.T) {
+	h := newTestHandler()
+	var tests = []struct {
+		name   string
+		params url.Values
+	}{
+		{"unregistered", withParams(url.Values{"redirect_uri": {"https://evil.example/cb"}})},
+		{"registered with a different path", withParams(url.Values{"redirect_uri": {"https://oauth.pstmn.io/v1/callback/evil"}})},
+		{"unknown client", withParams(url.Values{"client_id": {"nope"}})},
+	}
+
+	for _, tt := range tests {
+		w := newTestBrowser().authorize(h, tt.params)
+
+		// the error is shown to the user, never redirected to an unverified uri
+		assert.Equalf(t, http.StatusBadRequest, w.Code, "[%s] wrong status code", tt.name)
+		assert.Emptyf(t, w.Header().Get("Location"), "[%s] error was redirected", tt.name)
+		var oauthErr OAuthError
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &oauthErr))
+		assert.Equalf(t, "invalid_request", oauthErr.Code, "[%s] wrong error code", tt.name)
+	}
+}
+
+func TestAuthorizationEndpointHandler_OmittedRedirectUri(t *testing.T) {
+	h := newTestHandler()
+	params := withParams(url.Values{"scope": {"not.registered"}})
+	params.Del("redirect_uri")
+
+	// with one registered redirect uri it's the one errors and codes go to
+	query := redirectQuery(t, newTestBrowser().authorize(h, params), "https://oauth.pstmn.io/v1/callback")
+	assert.Equal(t, "invalid_scope", query.Get("error"))
+
+	// with several it has to be sent
+	h.ClientStore = multiRedirectClients
+	params.Set("client_id", "multi")
+	w := newTestBrowser().authorize(h, params)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Empty(t, w.Header().Get("Location"))
+
+	params.Set("redirect_uri", "https://b.example/cb")
+	redirectQuery(t, newTestBrowser().authorize(h, params), "https://b.example/cb")
+}
+
+func TestAuthorizationEndpointHandler_ErrorRedirect(t *testing.T) {
+	h := newTestHandler()
+	h.ClientStore = multiRedirectClients
+	params := withParams(url.Values{
+		"client_id":     {"query"},
+		"redirect_uri":  {"https://c.example/cb?tenant=jake"},
+		"response_type": {"token"},
+		"scope":         {"openid"},
+	})
+
+	w := newTestBrowser().authorize(h, params)
+	query := redirectQuery(t, w, "https://c.example/cb")
+
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "unsupported_response_type", query.Get("error"))
+	assert.NotEmpty(t, query.Get("error_description"))
+	assert.Equal(t, authorizationErrorUri, query.Get("error_uri"))
+	assert.Equal(t, "xyz", query.Get("state"))
+	assert.Equal(t, "jake", query.Get("tenant"), "the registered redirect uri's query was dropped")
+
+	// state is left out when the client didn't send one
+	params.Del("state")
+	query = redirectQuery(t, newTestBrowser().authorize(h, params), "https://c.example/cb")
+	assert.False(t, query.Has("state"))
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+)
+
+// consentPage is what consent.html is rendered with
+type consentPage struct {
+	Action     string
+	RequestId  string
+	CsrfToken  string
+	ClientName string
+	Username   string
+	Scopes     []scopeDescription
+	Error      string
+}
+
+// HandleConsent asks the user of the session if the client can have the scope it asked for. Allowing
+// sends the user back to the authorization endpoint for the code, denying sends access_denied to the client
+func (h *AuthHandler) HandleConsent(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	err := req.ParseForm()
+	if err != nil {
+		log.Println("error: HandleConsent, failed to parse form")
+		writeOAuthError(w, errInvalidRequest("The request could not be parsed."), authorizationErrorUri)
+		log.Println(err)
+		return
+	}
+
+	requestId := req.Form.Get("request_id")
+	request, err := h.Requests.Get(requestId)
+	if err != nil {
+		writeOAuthError(w, errInvalidRequest("The authorization request is unknown or has expired."), authorizationErrorUri)
+		log.Println("error:", err)
+		return
+	}
+	session, ok := h.currentSession(req)
+	if !ok {
+		http.Redirect(w, req, LoginPath+"?"+url.Values{"request_id": {requestId}}.Encode(), http.StatusSeeOther)
+		return
+	}
+	client, err := h.ClientStore.Client(request.ClientId)
+	if err != nil {
+		writeOAuthError(w, errServerError("The client could not be looked up."), authorizationErrorUri)
+		log.Println("error: failed to look up client:", err)
+		return
+	}
+
+	page := &consentPage{
+		Action:     ConsentPath,
+		RequestId:  requestId,
+		ClientName: client.Name,
+		Username:   session.Subject,
+		Scopes:     describeScopes(request.Scope),
+	}
+	if page.CsrfToken, err = csrfToken(w, req, requestId); err != nil {
+		writeOAuthError(w, errServerError("The consent page could not be rendered."), authorizationErrorUri)
+		log.Println("error: failed to create csrf token:", err)
+		return
+	}
+
+	if req.Method != http.MethodPost {
+		renderPage(w, http.StatusOK, "consent.html", page)
+		return
+	}
+
+	if !checkCsrf(req, requestId) {
+		page.Error = "The form has expired, please try again."
+		renderPage(w, http.StatusBadRequest, "consent.html", page)
+		log.Println("error: consent form had the wrong csrf token")
+		return
+	}
+
+	if req.PostForm.Get("decision") != "allow" {
+		// the request is finished, the client has to start over
+		if _, err = h.Requests.Take(requestId); err != nil {
+			log.Println("error:", err)
+		}
+		redirectWithError(w, req, request.RedirectUri, errAccessDenied("The resource owner denied the request."), request.State)
+		log.Printf("%s denied client %s\n", session.Subject, request.ClientId)
+		return
+	}
+
+	if err = h.Requests.Consent(requestId, session.Subject); err != nil {
+		writeOAuthError(w, errInvalidRequest("The authorization request is unknown or has expired."), authorizationErrorUri)
+		log.Println("error:", err)
+		return
+	}
+	http.Redirect(w, req, AuthorizationEndpointPath+"?"+url.Values{"request_id": {requestId}}.Encode(), http.StatusSeeOther)
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"JakeOAuth/auth"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// Paths the endpoints are served on, main.go registers the handlers with these so
+// the discovery document always points at the right place
+const (
+	AuthorizationEndpointPath = "/authorizationendpoint"
+	TokenEndpointPath         = "/tokenendpoint"
+	UserInfoEndpointPath      = "/userinfo"
+	IntrospectionEndpointPath = "/introspect"
+	RevocationEndpointPath    = "/revoke"
+	JwksPath                  = "/.well-known/jwks.json"
+	DiscoveryPath             = "/.well-known/openid-configuration"
+	// The pages and admin API aren't in the discovery document, they're not part of OAuth
+	LoginPath           = "/login"
+	ConsentPath         = "/consent"
+	AdminRateLimitsPath = "/admin/ratelimits"
+)
+
+// These are what the handlers actually accept, checked against the incoming requests
+var (
+	responseTypesSupported            = []string{"code"}
+	grantTypesSupported               = []string{"authorization_code", "client_credentials", "refresh_token"}
+	codeChallengeMethodsSupported     = []string{"plain", "S256"}
+	promptValuesSupported             = []string{"none", "login", "consent"}
+	tokenEndpointAuthMethodsSupported = []string{"client_secret_basic", "client_secret_post", "none"}
+	// introspection and revocation always need client authentication, so there is no "none"
+	clientAuthMethodsSupported = []string{"client_secret_basic", "client_secret_post"}
+)
+
+// ProviderMetadata is the OpenID Provider Metadata,
+// see https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata
+type ProviderMetadata struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JwksUri                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IdTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	// from OpenID Connect Prompt Create section 4.1
+	PromptValuesSupported []string `json:"prompt_values_supported"`
+	// from RFC 8414 section 2
+	IntrospectionEndpoint                     string   `json:"introspection_endpoint"`
+	IntrospectionEndpointAuthMethodsSupported []string `json:"introspection_endpoint_auth_methods_supported"`
+	RevocationEndpoint                        string   `json:"revocation_endpoint"`
+	RevocationEndpointAuthMethodsSupported    []string `json:"revocation_endpoint_auth_methods_supported"`
+}
+
+func NewProviderMetadata() *ProviderMetadata {
+	return &ProviderMetadata{
+		Issuer:                                    auth.Issuer,
+		AuthorizationEndpoint:                     auth.Issuer + AuthorizationEndpointPath,
+		TokenEndpoint:                             auth.Issuer + TokenEndpointPath,
+		UserInfoEndpoint:                          auth.Issuer + UserInfoEndpointPath,
+		JwksUri:                                   auth.Issuer + JwksPath,
+		ScopesSupported:                           auth.SupportedScopes(),
+		ResponseTypesSupported:                    responseTypesSupported,
+		GrantTypesSupported:                       grantTypesSupported,
+		SubjectTypesSupported:                     []string{"public"},
+		IdTokenSigningAlgValuesSupported:          auth.Keys.Algorithms(),
+		CodeChallengeMethodsSupported:             codeChallengeMethodsSupported,
+		TokenEndpointAuthMethodsSupported:         tokenEndpointAuthMethodsSupported,
+		PromptValuesSupported:                     promptValuesSupported,
+		IntrospectionEndpoint:                     auth.Issuer + IntrospectionEndpointPath,
+		IntrospectionEndpointAuthMethodsSupported: clientAuthMethodsSupported,
+		RevocationEndpoint:                        auth.Issuer + RevocationEndpointPath,
+		RevocationEndpointAuthMethodsSupported:    clientAuthMethodsSupported,
+	}
+}
+
+// DiscoveryHandler serves the OpenID Provider Configuration document
+func DiscoveryHandler(w http.ResponseWriter, req *http.Request) {
+	bytes, err := json.Marshal(NewProviderMetadata())
+	if err != nil {
+		log.Println("error: DiscoveryHandler failed to marshal provider metadata:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(bytes)
+	if err != nil {
+		log.Println("DiscoveryHandler: error writing response:", err)
+	}
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"JakeOAuth/auth"
+	"JakeOAuth/clients"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func discover(t *testing.T) ProviderMetadata {
+	w := httptest.NewRecorder()
+	DiscoveryHandler(w, httptest.NewRequest(http.MethodGet, DiscoveryPath, nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	var metadata ProviderMetadata
+	if err := json.Unmarshal(w.Body.Bytes(), &metadata); err != nil {
+		t.Fatal(err)
+	}
+	return metadata
+}
+
+func TestDiscoveryHandler(t *testing.T) {
+	metadata := discover(t)
+
+	assert.Equal(t, auth.Issuer, metadata.Issuer)
+	assert.Equal(t, auth.Issuer+AuthorizationEndpointPath, metadata.AuthorizationEndpoint)
+	assert.Equal(t, auth.Issuer+TokenEndpointPath, metadata.TokenEndpoint)
+	assert.Equal(t, auth.Issuer+UserInfoEndpointPath, metadata.UserInfoEndpoint)
+	assert.Equal(t, auth.Issuer+JwksPath, metadata.JwksUri)
+	assert.Equal(t, auth.Issuer+IntrospectionEndpointPath, metadata.IntrospectionEndpoint)
+	assert.Equal(t, auth.Issuer+RevocationEndpointPath, metadata.RevocationEndpoint)
+	assert.Equal(t, auth.SupportedScopes(), metadata.ScopesSupported)
+	assert.Equal(t, auth.Keys.Algorithms(), metadata.IdTokenSigningAlgValuesSupported)
+	assert.Equal(t, []string{"public"}, metadata.SubjectTypesSupported)
+}
+
+func TestDiscoveryHandler_GrantTypesSupported(t *testing.T) {
+	h := newTestHandler()
+
+	for _, grantType := range append(discover(t).GrantTypesSupported, "password") {
+		w := tokenRequest(h, url.Values{"grant_type": {grantType}})
+
+		var oauthErr OAuthError
+		_ = json.Unmarshal(w.Body.Bytes(), &oauthErr)
+		assert.Equalf(t, grantType == "password", oauthErr.Code == "unsupported_grant_type", "grant type %s", grantType)
+	}
+}
+
+func TestDiscoveryHandler_AuthorizationParametersSupported(t *testing.T) {
+	h := newTestHandler()
+	metadata := discover(t)
+	var tests = []struct {
+		param     string
+		supported []string
+		// unsupported is a value that isn't in the discovery document and is rejected
+		unsupported string
+	}{
+		{"response_type", metadata.ResponseTypesSupported, "token"},
+		{"code_challenge_method", metadata.CodeChallengeMethodsSupported, "S512"},
+		{"prompt", metadata.PromptValuesSupported, "select_account"},
+	}
+
+	for _, tt := range tests {
+		for _, value := range tt.supported {
+			w := newTestBrowser().authorize(h, withParams(url.Values{tt.param: {value}}))
+			location, _ := url.Parse(w.Header().Get("Location"))
+
+			// prompt=none without a session is a valid request that gets login_required
+			assert.NotContainsf(t, []string{"invalid_request", "unsupported_response_type"}, location.Query().Get("error"), "%s %s was rejected", tt.param, value)
+		}
+
+		w := newTestBrowser().authorize(h, withParams(url.Values{tt.param: {tt.unsupported}}))
+		location, _ := url.Parse(w.Header().Get("Location"))
+		assert.Containsf(t, []string{"invalid_request", "unsupported_response_type"}, location.Query().Get("error"), "%s %s was accepted", tt.param, tt.unsupported)
+	}
+}
+
+// clientAuthRequest authenticates a request to path with method, one of the *_auth_methods_supported
+func clientAuthRequest(path, method string, form url.Values, clientId, secret string) *http.Request {
+	form.Set("client_id", clientId)
+	if method == "client_secret_post" {
+		form.Set("client_secret", secret)
+	}
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if method == "client_secret_basic" {
+		req.SetBasicAuth(clientId, secret)
+	}
+	return req
+}
+
+func TestDiscoveryHandler_TokenEndpointAuthMethodsSupported(t *testing.T) {
+	h := newTestHandler()
+	h.ClientStore = clients.Clients{
+		ccClientId: testClients[ccClientId],
+		"public":   {ClientId: "public", Type: "authorization_code"},
+	}
+
+	for _, method := range discover(t).TokenEndpointAuthMethodsSupported {
+		var req *http.Request
+		if method == "none" {
+			rt, _ := h.TokenStore.Issue("grant", "public", "jake", "openid")
+			req = clientAuthRequest(TokenEndpointPath, method, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {rt.Token}}, "public", "")
+		} else {
+			req = clientAuthRequest(TokenEndpointPath, method, url.Values{"grant_type": {"client_credentials"}}, ccClientId, ccClientSecret)
+		}
+		w := httptest.NewRecorder()
+
+		h.TokenEndpointHandler(w, req)
+
+		assert.Equalf(t, http.StatusOK, w.Code, "token endpoint auth method %s", method)
+	}
+}
+
+func TestDiscoveryHandler_IntrospectionAndRevocationAuthMethodsSupported(t *testing.T) {
+	h := newTestHandler()
+	metadata := discover(t)
+	var tests = []struct {
+		name    string
+		path    string
+		methods []string
+		handler http.HandlerFunc
+	}{
+		{"introspection", IntrospectionEndpointPath, metadata.IntrospectionEndpointAuthMethodsSupported, h.IntrospectionHandler},
+		{"revocation", RevocationEndpointPath, metadata.RevocationEndpointAuthMethodsSupported, h.RevocationHandler},
+	}
+
+	for _, tt := range tests {
+		assert.NotContainsf(t, tt.methods, "none", "[%s] can't be used without client authentication", tt.name)
+		for _, method := range tt.methods {
+			w := httptest.NewRecorder()
+			tt.handler(w, clientAuthRequest(tt.path, method, url.Values{"token": {"not-a-token"}}, ccClientId, ccClientSecret))
+
+			assert.Equalf(t, http.StatusOK, w.Code, "[%s] auth method %s", tt.name, method)
+		}
+	}
+}
+
+func userInfo(token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, UserInfoEndpointPath, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	UserInfoHandler(w, req)
+	return w
+}
+
+func TestUserInfoHandler(t *testing.T) {
+	token, _ := auth.CreateJWT(nil, "jake", acClientId, auth.Issuer, "openid")
+
+	w := userInfo(token.Raw)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"sub":"jake"}`, w.Body.String())
+}
+
+func TestUserInfoHandler_InvalidToken(t *testing.T) {
+	idToken, _ := auth.CreateIDToken(&auth.AuthorizationCode{ClientId: acClientId, Subject: "jake", AuthTime: time.Now().Unix()}, "access-token", "RS256")
+	apiToken, _ := auth.CreateJWT(nil, "jake", acClientId, "https://api.example.com", "openid")
+	var tests = []struct {
+		name  string
+		token string
+	}{
+		{"no token", ""},
+		{"not a token", "nope"},
+		{"id token", idToken.Raw},
+		{"token for another resource", apiToken.Raw},
+	}
+
+	for _, tt := range tests {
+		w := userInfo(tt.token)
+
+		assert.Equalf(t, http.StatusUnauthorized, w.Code, "[%s] wrong status code", tt.name)
+		assert.NotEmptyf(t, w.Header().Get("WWW-Authenticate"), "[%s] missing WWW-Authenticate", tt.name)
+	}
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// The error_uri of each endpoint's errors, the section of its spec that defines them
+const (
+	authorizationErrorUri = "https://datatracker.ietf.org/doc/html/rfc6749#section-4.1.2.1"
+	tokenErrorUri         = "https://datatracker.ietf.org/doc/html/rfc6749#section-5.2"
+	introspectionErrorUri = "https://datatracker.ietf.org/doc/html/rfc7662#section-2.3"
+	revocationErrorUri    = "https://datatracker.ietf.org/doc/html/rfc7009#section-2.2.1"
+)
+
+// OAuthError is an error response as defined in RFC 6749 section 4.1.2.1 (authorization endpoint)
+// and section 5.2 (token endpoint)
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	Uri         string `json:"error_uri,omitempty"`
+	StatusCode  int    `json:"-"`
+
+	// cause is the internal reason for the error, it is logged but never sent to the client
+	cause error
+}
+
+func (e *OAuthError) Error() string {
+	if e.cause != nil {
+		return e.Code + ": " + e.Description + ": " + e.cause.Error()
+	}
+	return e.Code + ": " + e.Description
+}
+
+func (e *OAuthError) Unwrap() error {
+	return e.cause
+}
+
+// withCause attaches the internal reason for the error
+func (e *OAuthError) withCause(err error) *OAuthError {
+	e.cause = err
+	return e
+}
+
+func newOAuthError(statusCode int, code, description string) *OAuthError {
+	return &OAuthError{
+		Code:        code,
+		Description: description,
+		StatusCode:  statusCode,
+	}
+}
+
+func errInvalidRequest(description string) *OAuthError {
+	return newOAuthError(http.StatusBadRequest, "invalid_request", description)
+}
+
+// errInvalidClient is always a 401, the WWW-Authenticate header is added when it is written. It's only
+// for failed client authentication at the endpoints clients authenticate at (RFC 6749 section 5.2)
+func errInvalidClient(description string) *OAuthError {
+	return newOAuthError(http.StatusUnauthorized, "invalid_client", description)
+}
+
+func errInvalidGrant(description string) *OAuthError {
+	return newOAuthError(http.StatusBadRequest, "invalid_grant", description)
+}
+
+func errUnauthorizedClient(description string) *OAuthError {
+	return newOAuthError(http.StatusBadRequest, "unauthorized_client", description)
+}
+
+func errUnsupportedGrantType(description string) *OAuthError {
+	return newOAuthError(http.StatusBadRequest, "unsupported_grant_type", description)
+}
+
+func errUnsupportedResponseType(description string) *OAuthError {
+	return newOAuthError(http.StatusBadRequest, "unsupported_response_type", description)
+}
+
+func errInvalidScope(description string) *OAuthError {
+	return newOAuthError(http.StatusBadRequest, "invalid_scope", description)
+}
+
+// errInvalidTarget is for a resource parameter that is invalid or not allowed (RFC 8707 section 2)
+func errInvalidTarget(description string) *OAuthError {
+	return newOAuthError(http.StatusBadRequest, "invalid_target", description)
+}
+
+func errAccessDenied(description string) *OAuthError {
+	return newOAuthError(http.StatusForbidden, "access_denied", description)
+}
+
+// errTooManyRequests is sent with a Retry-After header. OAuth has no error code for rate limiting,
+// temporarily_unavailable is the closest (RFC 6749 section 4.1.2.1)
+func errTooManyRequests(description string) *OAuthError {
+	return newOAuthError(http.StatusTooManyRequests, "temporarily_unavailable", description)
+}
+
+func errLoginRequired(description string) *OAuthError {
+	return newOAuthError(http.StatusBadRequest, "login_required", description)
+}
+
+func errConsentRequired(description string) *OAuthError {
+	return newOAuthError(http.StatusBadRequest, "consent_required", description)
+}
+
+func errServerError(description string) *OAuthError {
+	return newOAuthError(http.StatusInternalServerError, "server_error", description)
+}
+
+// writeOAuthError writes oauthErr as a JSON error response, errorUri is the error_uri of the endpoint
+// writing it and is left out when empty. Responses with credentials or errors about them must not be
+// cached (RFC 6749 section 5.1)
+func writeOAuthError(w http.ResponseWriter, oauthErr *OAuthError, errorUri string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	if oauthErr.Code == "invalid_client" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="jakeoauth"`)
+	}
+	oauthErr.Uri = errorUri
+
+	bytes, err := json.Marshal(oauthErr)
+	if err != nil {
+		log.Println("error: failed to marshal error response:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(oauthErr.StatusCode)
+	_, err = w.Write(bytes)
+	if err != nil {
+		log.Println("Error writing response:", err)
+	}
+}
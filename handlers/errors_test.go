@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestWriteOAuthError(t *testing.T) {
+	var tests = []struct {
+		err        *OAuthError
+		statusCode int
+		code       string
+	}{
+		{errInvalidRequest("description"), http.StatusBadRequest, "invalid_request"},
+		{errInvalidClient("description"), http.StatusUnauthorized, "invalid_client"},
+		{errInvalidGrant("description"), http.StatusBadRequest, "invalid_grant"},
+		{errUnauthorizedClient("description"), http.StatusBadRequest, "unauthorized_client"},
+		{errUnsupportedGrantType("description"), http.StatusBadRequest, "unsupported_grant_type"},
+		{errUnsupportedResponseType("description"), http.StatusBadRequest, "unsupported_response_type"},
+		{errInvalidScope("description"), http.StatusBadRequest, "invalid_scope"},
+		{errInvalidTarget("description"), http.StatusBadRequest, "invalid_target"},
+		{errAccessDenied("description"), http.StatusForbidden, "access_denied"},
+		{errTooManyRequests("description"), http.StatusTooManyRequests, "temporarily_unavailable"},
+		{errLoginRequired("description"), http.StatusBadRequest, "login_required"},
+		{errConsentRequired("description"), http.StatusBadRequest, "consent_required"},
+		{errServerError("description"), http.StatusInternalServerError, "server_error"},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+
+		writeOAuthError(w, tt.err.withCause(errors.New("internal reason")), tokenErrorUri)
+
+		assert.Equalf(t, tt.statusCode, w.Code, "[%s] wrong status code", tt.code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+		assert.Equal(t, "no-cache", w.Header().Get("Pragma"))
+		assert.Equalf(t, tt.code == "invalid_client", w.Header().Get("WWW-Authenticate") != "", "[%s] WWW-Authenticate", tt.code)
+		var body map[string]string
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equalf(t, map[string]string{
+			"error":             tt.code,
+			"error_description": "description",
+			"error_uri":         tokenErrorUri,
+		}, body, "[%s] wrong body, the cause must not be sent", tt.code)
+	}
+}
+
+func TestWriteOAuthError_NoErrorUri(t *testing.T) {
+	w := httptest.NewRecorder()
+
+	writeOAuthError(w, errTooManyRequests("Too many requests."), "")
+
+	assert.Equal(t, `{"error":"temporarily_unavailable","error_description":"Too many requests."}`, w.Body.String())
+}
+
+func TestOAuthError_EndpointErrorUri(t *testing.T) {
+	h := newTestHandler()
+	formRequest := func(path string, form url.Values) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(ccClientId, ccClientSecret)
+		return req
+	}
+	var tests = []struct {
+		name     string
+		handler  http.HandlerFunc
+		req      *http.Request
+		errorUri string
+	}{
+		{"authorization", h.AuthorizationEndpointHandler, httptest.NewRequest(http.MethodGet, AuthorizationEndpointPath+"?client_id=nope", nil), authorizationErrorUri},
+		{"login", h.HandleLogin, httptest.NewRequest(http.MethodGet, LoginPath+"?request_id=nope", nil), authorizationErrorUri},
+		{"token", h.TokenEndpointHandler, formRequest(TokenEndpointPath, url.Values{}), tokenErrorUri},
+		{"introspection", h.IntrospectionHandler, formRequest(IntrospectionEndpointPath, url.Values{}), introspectionErrorUri},
+		{"revocation", h.RevocationHandler, formRequest(RevocationEndpointPath, url.Values{}), revocationErrorUri},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+
+		tt.handler(w, tt.req)
+
+		assert.Equalf(t, http.StatusBadRequest, w.Code, "[%s] wrong status code", tt.name)
+		assert.Emptyf(t, w.Header().Get("WWW-Authenticate"), "[%s] WWW-Authenticate on an error that isn't client authentication", tt.name)
+		var oauthErr OAuthError
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &oauthErr))
+		assert.Equalf(t, tt.errorUri, oauthErr.Uri, "[%s] wrong error_uri", tt.name)
+	}
+}
+
+func TestOAuthError_InvalidClient(t *testing.T) {
+	w := tokenRequest(newTestHandler(), clientCredentialsForm("wrong"))
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Basic realm="jakeoauth"`, w.Header().Get("WWW-Authenticate"))
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"JakeOAuth/auth"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+)
+
+// IntrospectionResponse is the token introspection response, see RFC 7662 section 2.2.
+// Everything but Active is left out for tokens that aren't active
+type IntrospectionResponse struct {
+	Active    bool     `json:"active"`
+	Scope     string   `json:"scope,omitempty"`
+	ClientId  string   `json:"client_id,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Exp       int64    `json:"exp,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	Audience  []string `json:"aud,omitempty"`
+	Jti       string   `json:"jti,omitempty"`
+}
+
+// IntrospectionHandler lets resource servers ask if a token is still active and what it was issued for.
+// The caller has to authenticate like a confidential client at the token endpoint (RFC 7662 section 2.1).
+// A client can only introspect its own tokens unless it's registered as a resource server
+func (h *AuthHandler) IntrospectionHandler(w http.ResponseWriter, req *http.Request) {
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			log.Println("IntrospectionHandler: failed to close body")
+		}
+	}(req.Body)
+
+	resp, oauthErr := h.introspectionResponse(req)
+	if oauthErr != nil {
+		log.Println("error: IntrospectionHandler:", oauthErr)
+		writeOAuthError(w, oauthErr, introspectionErrorUri)
+		return
+	}
+
+	bytes, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("error: IntrospectionHandler failed to marshal introspection response:", err)
+		writeOAuthError(w, errServerError("Failed to marshal introspection response."), introspectionErrorUri)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(bytes)
+	if err != nil {
+		log.Println("IntrospectionHandler: error writing response:", err)
+	}
+}
+
+func (h *AuthHandler) introspectionResponse(req *http.Request) (*IntrospectionResponse, *OAuthError) {
+	err := req.ParseForm()
+	if err != nil {
+		return nil, errInvalidRequest("The request body could not be parsed.").withCause(err)
+	}
+
+	formVals := req.Form
+	app, oauthErr := h.authenticateClient(formVals, req)
+	if oauthErr != nil {
+		return nil, oauthErr
+	}
+
+	if !formVals.Has("token") {
+		return nil, errInvalidRequest("The request is missing the token parameter.")
+	}
+	token := formVals.Get("token")
+
+	// the hint only decides what is looked up first, the other type is still checked (RFC 7662 section 2.1)
+	lookups := []func(string) (*IntrospectionResponse, bool){introspectAccessToken, h.introspectRefreshToken}
+	if formVals.Get("token_type_hint") == "refresh_token" {
+		lookups[0], lookups[1] = lookups[1], lookups[0]
+	}
+
+	for _, lookup := range lookups {
+		resp, ok := lookup(token)
+		if !ok {
+			continue
+		}
+		// another client's token looks the same as an unknown one, so the caller can't probe for tokens (RFC 7662 section 4)
+		if resp.ClientId != app.ClientId && !app.ResourceServer {
+			log.Printf("error: client %s tried to introspect token %s of client %s\n", app.ClientId, resp.Jti, resp.ClientId)
+			break
+		}
+		log.Printf("client %s introspected active token %s\n", app.ClientId, resp.Jti)
+		return resp, nil
+	}
+
+	return &IntrospectionResponse{Active: false}, nil
+}
+
+func introspectAccessToken(token string) (*IntrospectionResponse, bool) {
+	claims, err := auth.ParseAccessToken(token, "")
+	if err != nil {
+		return nil, false
+	}
+
+	resp := &IntrospectionResponse{
+		Active:    true,
+		Scope:     claims.Scope,
+		ClientId:  claims.ClientId,
+		Subject:   claims.Subject,
+		TokenType: "Bearer",
+		Audience:  claims.Audience,
+		Jti:       claims.ID,
+	}
+	if claims.ExpiresAt != nil {
+		resp.Exp = claims.ExpiresAt.Unix()
+	}
+	if claims.IssuedAt != nil {
+		resp.IssuedAt = claims.IssuedAt.Unix()
+	}
+	return resp, true
+}
+
+func (h *AuthHandler) introspectRefreshToken(token string) (*IntrospectionResponse, bool) {
+	rt, err := h.TokenStore.Peek(token)
+	if err != nil {
+		if !errors.Is(err, auth.ErrRefreshTokenNotFound) {
+			log.Println("error: failed to look up refresh token:", err)
+		}
+		return nil, false
+	}
+	if !rt.Active() {
+		return nil, false
+	}
+
+	// refresh tokens are only ever presented back to this server's token endpoint.
+	// token_type is left out, it's the access token type of RFC 6749 section 7.1 and a refresh token has none
+	return &IntrospectionResponse{
+		Active:   true,
+		Scope:    rt.Scope,
+		ClientId: rt.ClientId,
+		Subject:  rt.Subject,
+		Exp:      rt.Exp,
+		IssuedAt: rt.IssuedAt,
+		Audience: []string{auth.Issuer},
+		Jti:      rt.Id,
+	}, true
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"JakeOAuth/auth"
+	"JakeOAuth/clients"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func introspect(h *AuthHandler, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, IntrospectionEndpointPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(ccClientId, ccClientSecret)
+	w := httptest.NewRecorder()
+	h.IntrospectionHandler(w, req)
+	return w
+}
+
+func TestIntrospectionHandler_AccessToken(t *testing.T) {
+	h := newTestHandler()
+	var tokenResp AccessTokenResponse
+	_ = json.Unmarshal(tokenRequest(h, clientCredentialsForm(ccClientSecret)).Body.Bytes(), &tokenResp)
+
+	w := introspect(h, url.Values{"token": {tokenResp.AccessToken}})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp IntrospectionResponse
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.True(t, resp.Active)
+	assert.Equal(t, ccClientId, resp.ClientId)
+	assert.Equal(t, ccClientId, resp.Subject)
+	assert.Equal(t, "api.read api.write", resp.Scope)
+	assert.Equal(t, "Bearer", resp.TokenType)
+	assert.Equal(t, []string{auth.Issuer}, resp.Audience)
+	assert.NotEmpty(t, resp.Jti)
+	assert.NotZero(t, resp.Exp)
+	assert.NotZero(t, resp.IssuedAt)
+}
+
+func TestIntrospectionHandler_RefreshToken(t *testing.T) {
+	h := newTestHandler()
+	rt, _ := h.TokenStore.Issue("", ccClientId, "jakedanson", "openid")
+
+	w := introspect(h, url.Values{"token": {rt.Token}, "token_type_hint": {"refresh_token"}})
+
+	var resp IntrospectionResponse
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.True(t, resp.Active)
+	assert.Equal(t, "jakedanson", resp.Subject)
+	assert.Empty(t, resp.TokenType)
+	assert.Equal(t, rt.Id, resp.Jti)
+
+	// a rotated refresh token can't be used anymore
+	_, _ = h.TokenStore.Rotate(rt.Token, ccClientId)
+	w = introspect(h, url.Values{"token": {rt.Token}})
+
+	assert.Equal(t, `{"active":false}`, w.Body.String())
+}
+
+func TestIntrospectionHandler_Inactive(t *testing.T) {
+	h := newTestHandler()
+
+	w := introspect(h, url.Values{"token": {"not-a-token"}})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"active":false}`, w.Body.String())
+}
+
+func TestIntrospectionHandler_Unauthenticated(t *testing.T) {
+	h := newTestHandler()
+	req := httptest.NewRequest(http.MethodPost, IntrospectionEndpointPath, strings.NewReader("token=abc"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+
+	h.IntrospectionHandler(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestIntrospectionHandler_OtherClientsToken(t *testing.T) {
+	h := newTestHandler()
+	rt, _ := h.TokenStore.Issue("", acClientId, "jakedanson", "openid")
+	token, _ := auth.CreateJWT(nil, "jakedanson", acClientId, auth.Issuer, "openid")
+
+	for _, tokenString := range []string{rt.Token, token.Raw} {
+		w := introspect(h, url.Values{"token": {tokenString}})
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `{"active":false}`, w.Body.String())
+	}
+}
+
+func TestIntrospectionHandler_ResourceServer(t *testing.T) {
+	h := newTestHandler()
+	resourceServer := testClients[ccClientId]
+	resourceServer.ResourceServer = true
+	h.ClientStore = clients.Clients{ccClientId: resourceServer}
+	rt, _ := h.TokenStore.Issue("", acClientId, "jakedanson", "openid")
+	token, _ := auth.CreateJWT(nil, "jakedanson", acClientId, auth.Issuer, "openid")
+
+	for _, tokenString := range []string{rt.Token, token.Raw} {
+		w := introspect(h, url.Values{"token": {tokenString}})
+
+		var resp IntrospectionResponse
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.True(t, resp.Active)
+		assert.Equal(t, acClientId, resp.ClientId)
+	}
+}
@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"JakeOAuth/auth"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// JwksHandler serves the public keys tokens are signed with so resource servers
+// can validate them without being handed the key out-of-band
+func JwksHandler(w http.ResponseWriter, req *http.Request) {
+	bytes, err := json.Marshal(auth.Keys.JWKS())
+	if err != nil {
+		log.Println("error: JwksHandler failed to marshal jwks:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(bytes)
+	if err != nil {
+		log.Println("JwksHandler: error writing response:", err)
+	}
+}
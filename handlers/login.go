@@ -2,79 +2,132 @@ package handlers
 
 import (
 	"JakeOAuth/clients"
-	"JakeOAuth/util"
-	"fmt"
+	"errors"
 	"log"
 	"net/http"
+	"net/url"
 )
 
-// TODO: make this not static...in a database or something
-var usersSlice map[string]clients.User
-
-func init() {
-	usersSlice = clients.ReadUsers("clients/users.json")
-}
-
-func HandleInitRedirect(w http.ResponseWriter, req *http.Request) {
-	url := fmt.Sprintf("http://localhost:5173?state=%s&client_id=%s&grant_type=authorization_code", "state", "f3bf97cd-91c0-494a-8c91-5ec6b14375d5")
-	http.Redirect(w, req, url, http.StatusFound)
-	return
+// loginPage is what login.html is rendered with
+type loginPage struct {
+	Action     string
+	RequestId  string
+	CsrfToken  string
+	ClientName string
+	Username   string
+	Error      string
 }
 
-func HandleLogin(w http.ResponseWriter, req *http.Request) {
+// HandleLogin renders the login page for an authorization request on GET, and logs the user in on POST
+// with a new session. The user is then sent back to the authorization endpoint to carry on with the request
+func (h *AuthHandler) HandleLogin(w http.ResponseWriter, req *http.Request) {
 	defer req.Body.Close()
 
 	err := req.ParseForm()
 	if err != nil {
-		log.Println("error: AuthorizationEndpointHandler, failed to parse form")
-		w.Write([]byte("unauthorized_client:The authorization server encountered an unexpected condition that prevented it from fulfilling the request."))
+		log.Println("error: HandleLogin, failed to parse form")
+		writeOAuthError(w, errInvalidRequest("The request could not be parsed."), authorizationErrorUri)
 		log.Println(err)
 		return
 	}
 
-	formVals := req.Form
+	requestId := req.Form.Get("request_id")
+	request, err := h.Requests.Get(requestId)
+	if err != nil {
+		writeOAuthError(w, errInvalidRequest("The authorization request is unknown or has expired."), authorizationErrorUri)
+		log.Println("error:", err)
+		return
+	}
+	client, err := h.ClientStore.Client(request.ClientId)
+	if err != nil {
+		writeOAuthError(w, errServerError("The client could not be looked up."), authorizationErrorUri)
+		log.Println("error: failed to look up client:", err)
+		return
+	}
 
-	encodedStr := req.Header.Get("Authorization")
+	page := &loginPage{Action: LoginPath, RequestId: requestId, ClientName: client.Name}
+	if page.CsrfToken, err = csrfToken(w, req, requestId); err != nil {
+		writeOAuthError(w, errServerError("The login page could not be rendered."), authorizationErrorUri)
+		log.Println("error: failed to create csrf token:", err)
+		return
+	}
 
-	if encodedStr == "" {
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte("request sent in bad format; use Authorization Header with username:password base64url encoded"))
-		log.Println("error: Authorization header missing or empty")
+	if req.Method != http.MethodPost {
+		renderPage(w, http.StatusOK, "login.html", page)
 		return
 	}
 
-	username, password, err := util.DecodeBase64(encodedStr)
+	if !checkCsrf(req, requestId) {
+		page.Error = "The form has expired, please try again."
+		renderPage(w, http.StatusBadRequest, "login.html", page)
+		log.Println("error: login form had the wrong csrf token")
+		return
+	}
+
+	username := req.PostForm.Get("username")
+	password := req.PostForm.Get("password")
+	page.Username = username
+
+	// an unknown user and a wrong password get the same response, so it doesn't tell which usernames exist
+	user, err := h.UserStore.User(username)
+	if errors.Is(err, clients.ErrUserNotFound) {
+		clients.VerifyDummyPassword(password)
+		page.Error = "The username or password is incorrect."
+		renderPage(w, http.StatusForbidden, "login.html", page)
+		log.Printf("error: username does not exist %s\n", username)
+		return
+	}
+	if err != nil {
+		page.Error = "Something went wrong, please try again."
+		renderPage(w, http.StatusInternalServerError, "login.html", page)
+		log.Println("error: failed to look up user:", err)
+		return
+	}
 
+	ok, needsRehash, err := clients.VerifyPassword(user.PasswordHash, password)
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte("authorization header unable to be decoded"))
-		log.Printf("error: authorization header unable to be decoded %v\n", err)
+		page.Error = "Something went wrong, please try again."
+		renderPage(w, http.StatusInternalServerError, "login.html", page)
+		log.Printf("error: failed to verify password of %s: %s\n", username, err)
+		return
+	}
+	if !ok {
+		page.Error = "The username or password is incorrect."
+		renderPage(w, http.StatusForbidden, "login.html", page)
+		log.Printf("error: wrong password for %s\n", username)
 		return
 	}
 
-	// check username and password against database...for now hardcoded af
-	var user clients.User
-	if u, ok := usersSlice[username]; !ok {
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte("user " + username + " does not exist"))
-		log.Printf("error: username does not exist %s\n", user)
+	// the password is only available now, so this is the only time the hash can be upgraded
+	if needsRehash {
+		h.rehashPassword(username, password)
+	}
+
+	if err = h.Requests.Authenticate(requestId, username); err != nil {
+		writeOAuthError(w, errInvalidRequest("The authorization request is unknown or has expired."), authorizationErrorUri)
+		log.Println("error:", err)
+		return
+	}
+	if _, err = h.startSession(w, req, username); err != nil {
+		page.Error = "Something went wrong, please try again."
+		renderPage(w, http.StatusInternalServerError, "login.html", page)
+		log.Println("error: failed to start session:", err)
 		return
-	} else {
-		user = u
 	}
+	http.Redirect(w, req, AuthorizationEndpointPath+"?"+url.Values{"request_id": {requestId}}.Encode(), http.StatusSeeOther)
+}
 
-	if user.Password != password {
-		w.WriteHeader(http.StatusForbidden)
-		// don't ever log passwords...this is for education purposes only
-		w.Write([]byte("password " + password + " is the wrong password"))
-		log.Printf("error: username and password combo does not match %s:%s\n", username, password)
+// rehashPassword upgrades the stored hash to the current PasswordParams, a failure is only
+// logged since the user did log in with the right password
+func (h *AuthHandler) rehashPassword(username, password string) {
+	hash, err := clients.HashPassword(password)
+	if err != nil {
+		log.Printf("error: failed to rehash password of %s: %s\n", username, err)
+		return
+	}
+	if err = h.UserStore.SetPasswordHash(username, hash); err != nil {
+		log.Printf("error: failed to save rehashed password of %s: %s\n", username, err)
 		return
 	}
-	// maybe handle verification (OIDC?) that you accept what the application is going to do on
-	// your behalf
-	// TODO: Grab from query string parameters to fill out below redirect url
-	url := fmt.Sprintf("http://localhost:8080/authorizationendpoint?client_id=%s&response_type=%s&code_challenge=%s&code_challenge_method=%s&state=%s", formVals.Get("client_id"),
-		formVals.Get("response_type"), formVals.Get("code_challenge"), formVals.Get("code_challenge_method"), formVals.Get("state"))
-	http.Redirect(w, req, url, http.StatusFound)
-	return
+	log.Printf("rehashed password of %s\n", username)
 }
@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"JakeOAuth/auth"
+	"JakeOAuth/clients"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const acClientId = "f3bf97cd-91c0-494a-8c91-5ec6b14375d5"
+
+var csrfTokenPattern = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+// newTestRequest stores an authorization request like the authorization endpoint does and returns its id
+func newTestRequest(t *testing.T, h *AuthHandler) string {
+	requestId, err := h.Requests.Add(auth.AuthorizationRequest{
+		ClientId:            acClientId,
+		RedirectUri:         "https://oauth.pstmn.io/v1/callback",
+		State:               "xyz",
+		Scope:               "openid api.read",
+		CodeChallenge:       "verifier",
+		CodeChallengeMethod: "plain",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return requestId
+}
+
+// testBrowser keeps the cookies the handlers set, and sends them along with every request like a browser would
+type testBrowser struct {
+	cookies map[string]*http.Cookie
+}
+
+func newTestBrowser() *testBrowser {
+	return &testBrowser{cookies: make(map[string]*http.Cookie)}
+}
+
+func (b *testBrowser) do(handler http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+	for _, c := range b.cookies {
+		req.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	handler(w, req)
+	for _, c := range w.Result().Cookies() {
+		b.cookies[c.Name] = c
+	}
+	return w
+}
+
+// loadPage GETs a page and returns the CSRF token in its form
+func (b *testBrowser) loadPage(t *testing.T, handler http.HandlerFunc, path, requestId string) string {
+	w := b.do(handler, httptest.NewRequest(http.MethodGet, path+"?"+url.Values{"request_id": {requestId}}.Encode(), nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	match := csrfTokenPattern.FindStringSubmatch(w.Body.String())
+	if match == nil {
+		t.Fatalf("%s has no csrf token", path)
+	}
+	return match[1]
+}
+
+func (b *testBrowser) submitPage(handler http.HandlerFunc, path string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return b.do(handler, req)
+}
+
+func (b *testBrowser) login(t *testing.T, h *AuthHandler, requestId, username, password string) *httptest.ResponseRecorder {
+	token := b.loadPage(t, h.HandleLogin, LoginPath, requestId)
+	form := url.Values{"request_id": {requestId}, "csrf_token": {token}, "username": {username}, "password": {password}}
+	return b.submitPage(h.HandleLogin, LoginPath, form)
+}
+
+func TestHandleLogin(t *testing.T) {
+	h := newTestHandler()
+	requestId := newTestRequest(t, h)
+	b := newTestBrowser()
+
+	w := b.login(t, h, requestId, "jake", "password")
+
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, AuthorizationEndpointPath+"?request_id="+requestId, w.Header().Get("Location"))
+	request, _ := h.Requests.Get(requestId)
+	assert.Equal(t, "jake", request.Subject)
+
+	cookie := b.cookies[sessionCookieName]
+	if assert.NotNil(t, cookie) {
+		assert.True(t, cookie.HttpOnly)
+		assert.True(t, cookie.Secure)
+		assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+		session, err := h.SessionStore.Get(cookie.Value)
+		assert.Nil(t, err)
+		assert.Equal(t, "jake", session.Subject)
+	}
+}
+
+func TestHandleLogin_NewSession(t *testing.T) {
+	h := newTestHandler()
+	b := newTestBrowser()
+	b.login(t, h, newTestRequest(t, h), "jake", "password")
+	first := b.cookies[sessionCookieName].Value
+
+	b.login(t, h, newTestRequest(t, h), "jake", "password")
+
+	assert.NotEqual(t, first, b.cookies[sessionCookieName].Value, "logging in always starts a new session")
+	_, err := h.SessionStore.Get(first)
+	assert.ErrorIs(t, err, auth.ErrSessionNotFound)
+}
+
+func TestHandleLogin_Page(t *testing.T) {
+	h := newTestHandler()
+	requestId := newTestRequest(t, h)
+	req := httptest.NewRequest(http.MethodGet, LoginPath+"?request_id="+requestId, nil)
+	w := httptest.NewRecorder()
+
+	h.HandleLogin(w, req)
+
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "DENY", w.Header().Get("X-Frame-Options"))
+	assert.Contains(t, w.Body.String(), "jake_ac_grant")
+	cookie := w.Result().Cookies()[0]
+	assert.Equal(t, csrfCookieName, cookie.Name)
+	assert.True(t, cookie.HttpOnly)
+	assert.True(t, cookie.Secure)
+	assert.Equal(t, http.SameSiteStrictMode, cookie.SameSite)
+}
+
+func TestHandleLogin_WrongCredentials(t *testing.T) {
+	h := newTestHandler()
+	requestId := newTestRequest(t, h)
+
+	b := newTestBrowser()
+	wrongPassword := b.login(t, h, requestId, "jake", "hunter2")
+	unknownUser := b.login(t, h, requestId, "nobody", "hunter2")
+
+	assert.Equal(t, http.StatusForbidden, wrongPassword.Code)
+	assert.Equal(t, http.StatusForbidden, unknownUser.Code)
+	assert.Contains(t, wrongPassword.Body.String(), "The username or password is incorrect.")
+	assert.Contains(t, unknownUser.Body.String(), "The username or password is incorrect.")
+	assert.NotContains(t, wrongPassword.Body.String(), "hunter2")
+	request, _ := h.Requests.Get(requestId)
+	assert.Empty(t, request.Subject)
+	assert.Nil(t, b.cookies[sessionCookieName])
+}
+
+func TestHandleLogin_Csrf(t *testing.T) {
+	h := newTestHandler()
+	requestId := newTestRequest(t, h)
+	b := newTestBrowser()
+	token := b.loadPage(t, h.HandleLogin, LoginPath, requestId)
+	other := newTestBrowser()
+	other.loadPage(t, h.HandleLogin, LoginPath, requestId)
+	otherRequestId := newTestRequest(t, h)
+
+	var tests = []struct {
+		name    string
+		form    url.Values
+		browser *testBrowser
+	}{
+		{"missing token", url.Values{"request_id": {requestId}}, b},
+		{"missing cookie", url.Values{"request_id": {requestId}, "csrf_token": {token}}, newTestBrowser()},
+		{"another browser", url.Values{"request_id": {requestId}, "csrf_token": {token}}, other},
+		{"another request", url.Values{"request_id": {otherRequestId}, "csrf_token": {token}}, b},
+	}
+
+	for _, tt := range tests {
+		tt.form.Set("username", "jake")
+		tt.form.Set("password", "password")
+		w := tt.browser.submitPage(h.HandleLogin, LoginPath, tt.form)
+
+		assert.Equalf(t, http.StatusBadRequest, w.Code, "[%s] wrong status code", tt.name)
+	}
+	request, _ := h.Requests.Get(requestId)
+	assert.Empty(t, request.Subject)
+}
+
+func TestHandleLogin_UnknownRequest(t *testing.T) {
+	h := newTestHandler()
+	req := httptest.NewRequest(http.MethodGet, LoginPath+"?request_id=nope", nil)
+	w := httptest.NewRecorder()
+
+	h.HandleLogin(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestHandleLogin_RehashesBcrypt(t *testing.T) {
+	b, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	users, err := openTestUsers(filepath.Join(t.TempDir(), "users.json"), `{"jake": {"password_hash": "`+string(b)+`"}}`)
+	assert.Nil(t, err)
+	h := newTestHandler()
+	h.UserStore = users
+
+	w := newTestBrowser().login(t, h, newTestRequest(t, h), "jake", "password")
+
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	user, _ := users.User("jake")
+	assert.True(t, strings.HasPrefix(user.PasswordHash, "$argon2id$"))
+	ok, needsRehash, _ := clients.VerifyPassword(user.PasswordHash, "password")
+	assert.True(t, ok)
+	assert.False(t, needsRehash)
+}
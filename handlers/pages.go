@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"JakeOAuth/auth"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"html/template"
+	"log"
+	"net/http"
+)
+
+//go:embed templates/*.html
+var templateFiles embed.FS
+
+var templates = template.Must(template.ParseFS(templateFiles, "templates/*.html"))
+
+// csrfCookieName is the cookie with the browser's CSRF secret, the forms carry a token derived from it
+const csrfCookieName = "jakeoauth_csrf"
+
+// scopeDescription is a scope with what it grants, shown on the consent page
+type scopeDescription struct {
+	Name        string
+	Description string
+}
+
+func describeScopes(scope string) []scopeDescription {
+	scopes := auth.ParseScope(scope)
+	descriptions := make([]scopeDescription, 0, len(scopes))
+	for _, s := range scopes {
+		descriptions = append(descriptions, scopeDescription{Name: s, Description: auth.ScopeRegistry[s]})
+	}
+	return descriptions
+}
+
+// renderPage executes the template into a buffer first, so a failed template doesn't leave a half written page
+func renderPage(w http.ResponseWriter, status int, name string, data any) {
+	buf := &bytes.Buffer{}
+	if err := templates.ExecuteTemplate(buf, name, data); err != nil {
+		log.Printf("error: failed to render %s: %s\n", name, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	// the pages can't be framed, otherwise the consent button could be clickjacked
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+	w.WriteHeader(status)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		log.Printf("error writing %s: %s\n", name, err)
+	}
+}
+
+// csrfToken returns the CSRF token for the forms of an authorization request. It's an HMAC of the request id
+// with a secret kept in a cookie, so the form can only be posted from the browser that loaded it
+func csrfToken(w http.ResponseWriter, req *http.Request, requestId string) (string, error) {
+	secret := ""
+	if cookie, err := req.Cookie(csrfCookieName); err == nil && cookie.Value != "" {
+		secret = cookie.Value
+	} else {
+		b := make([]byte, 32)
+		if _, err = rand.Read(b); err != nil {
+			return "", err
+		}
+		secret = hex.EncodeToString(b)
+		// browsers accept Secure cookies from http://localhost, so this works in development too
+		http.SetCookie(w, &http.Cookie{
+			Name:     csrfCookieName,
+			Value:    secret,
+			Path:     "/",
+			Secure:   true,
+			HttpOnly: true,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+	return csrfMac(secret, requestId), nil
+}
+
+// checkCsrf checks the csrf_token of a posted form against the browser's CSRF cookie
+func checkCsrf(req *http.Request, requestId string) bool {
+	cookie, err := req.Cookie(csrfCookieName)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	return hmac.Equal([]byte(req.PostForm.Get("csrf_token")), []byte(csrfMac(cookie.Value, requestId)))
+}
+
+func csrfMac(secret, requestId string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(requestId))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"JakeOAuth/auth"
+	"JakeOAuth/ratelimit"
+	"JakeOAuth/util"
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AdminScope is the scope an access token needs to use the admin API
+const AdminScope = "admin.read"
+
+// Default rate limit policies. A username locks out sooner than a client or an IP address since many
+// users can share one IP address, and a client can make a lot of legitimate requests
+var (
+	UserPolicy = ratelimit.Policy{
+		Burst:       10,
+		Interval:    6 * time.Second,
+		MaxFailures: 5,
+		Lockout:     30 * time.Second,
+		MaxLockout:  15 * time.Minute,
+		ForgetAfter: 1 * time.Hour,
+	}
+	ClientPolicy = ratelimit.Policy{
+		Burst:       50,
+		Interval:    100 * time.Millisecond,
+		MaxFailures: 10,
+		Lockout:     30 * time.Second,
+		MaxLockout:  15 * time.Minute,
+		ForgetAfter: 1 * time.Hour,
+	}
+	IPPolicy = ratelimit.Policy{
+		Burst:       100,
+		Interval:    100 * time.Millisecond,
+		MaxFailures: 20,
+		Lockout:     30 * time.Second,
+		MaxLockout:  15 * time.Minute,
+		ForgetAfter: 1 * time.Hour,
+	}
+)
+
+// RateLimits protects the endpoints that check a password or client secret against brute force attacks,
+// every request is limited per IP address and per username or client id
+type RateLimits struct {
+	Users   *ratelimit.Limiter
+	Clients *ratelimit.Limiter
+	IPs     *ratelimit.Limiter
+}
+
+func NewRateLimits() *RateLimits {
+	return &RateLimits{
+		Users:   ratelimit.New("users", UserPolicy),
+		Clients: ratelimit.New("clients", ClientPolicy),
+		IPs:     ratelimit.New("ips", IPPolicy),
+	}
+}
+
+// limitKey is a key to limit a request by and the Limiter it's limited in
+type limitKey struct {
+	limiter *ratelimit.Limiter
+	key     string
+}
+
+// Login limits HandleLogin per IP address and username, a 403 from it is a wrong username or password
+func (rl *RateLimits) Login(next http.HandlerFunc) http.HandlerFunc {
+	return rl.limit(next, http.StatusForbidden, func(req *http.Request) []limitKey {
+		keys := []limitKey{{rl.IPs, clientIP(req)}}
+		if err := req.ParseForm(); err == nil && req.PostForm.Has("username") {
+			keys = append(keys, limitKey{rl.Users, req.PostForm.Get("username")})
+		}
+		return keys
+	})
+}
+
+// ClientAuth limits the endpoints clients authenticate at per IP address and client id,
+// a 401 from them is a wrong client secret
+func (rl *RateLimits) ClientAuth(next http.HandlerFunc) http.HandlerFunc {
+	return rl.limit(next, http.StatusUnauthorized, func(req *http.Request) []limitKey {
+		keys := []limitKey{{rl.IPs, clientIP(req)}}
+		clientId := ""
+		if header := req.Header.Get("Authorization"); header != "" {
+			clientId, _, _ = util.DecodeBasicAuth(header)
+		} else if err := req.ParseForm(); err == nil {
+			clientId = req.PostForm.Get("client_id")
+		}
+		if clientId != "" {
+			keys = append(keys, limitKey{rl.Clients, clientId})
+		}
+		return keys
+	})
+}
+
+// limit responds with a 429 when any of the request's keys is out of requests or locked out. Otherwise
+// next handles the request, and its keys fail when it responds with failureStatus
+func (rl *RateLimits) limit(next http.HandlerFunc, failureStatus int, keysOf func(req *http.Request) []limitKey) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		keys := keysOf(req)
+
+		var retryAfter time.Duration
+		for _, k := range keys {
+			if ok, wait := k.limiter.Allow(k.key); !ok {
+				retryAfter = max(retryAfter, wait)
+				log.Printf("%s rate limit: %s is limited for %s\n", k.limiter.Name(), k.key, wait)
+			}
+		}
+		if retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			// the limiter is in front of several endpoints, none of which define this error
+			writeOAuthError(w, errTooManyRequests("Too many requests, try again later."), "")
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, req)
+
+		for _, k := range keys {
+			if rec.status == failureStatus {
+				k.limiter.Fail(k.key)
+			} else if rec.status < http.StatusBadRequest {
+				k.limiter.Succeed(k.key)
+			}
+		}
+	}
+}
+
+// StartPruning prunes every Limiter every interval until ctx is done
+func (rl *RateLimits) StartPruning(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rl.Users.Prune()
+			rl.Clients.Prune()
+			rl.IPs.Prune()
+		}
+	}
+}
+
+// RateLimitsResponse is what the admin API shows for every Limiter
+type RateLimitsResponse struct {
+	Users   []ratelimit.Stat `json:"users"`
+	Clients []ratelimit.Stat `json:"clients"`
+	IPs     []ratelimit.Stat `json:"ips"`
+}
+
+// AdminRateLimitsHandler shows the rate limit counters, it needs an access token for this server with the
+// admin.read scope. A token the client got for another resource with the resource parameter isn't enough
+func (rl *RateLimits) AdminRateLimitsHandler(w http.ResponseWriter, req *http.Request) {
+	tokenString, found := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !found || tokenString == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := auth.ParseAccessToken(tokenString, auth.Issuer)
+	if err != nil {
+		log.Println("error: AdminRateLimitsHandler failed to validate access token:", err)
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if !hasScope(claims.Scope, AdminScope) {
+		log.Printf("error: AdminRateLimitsHandler was sent a token for %s without the admin.read scope\n", claims.Subject)
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="admin.read"`)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	bytes, err := json.Marshal(&RateLimitsResponse{
+		Users:   rl.Users.Stats(),
+		Clients: rl.Clients.Stats(),
+		IPs:     rl.IPs.Stats(),
+	})
+	if err != nil {
+		log.Println("error: AdminRateLimitsHandler failed to marshal response:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_, err = w.Write(bytes)
+	if err != nil {
+		log.Println("AdminRateLimitsHandler: error writing response:", err)
+	}
+}
+
+// statusRecorder remembers the status code a handler responded with
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// clientIP is the address the request came from. X-Forwarded-For isn't trusted, anyone can send it
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"JakeOAuth/auth"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRateLimits_Login(t *testing.T) {
+	h := newTestHandler()
+	handler := NewRateLimits().Login(h.HandleLogin)
+	requestId := newTestRequest(t, h)
+	b := newTestBrowser()
+	token := b.loadPage(t, handler, LoginPath, requestId)
+	attempt := func(password string) *httptest.ResponseRecorder {
+		form := url.Values{"request_id": {requestId}, "csrf_token": {token}, "username": {"jake"}, "password": {password}}
+		return b.submitPage(handler, LoginPath, form)
+	}
+
+	for i := 0; i < UserPolicy.MaxFailures; i++ {
+		assert.Equal(t, http.StatusForbidden, attempt("wrong").Code)
+	}
+
+	w := attempt("password")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, "the right password doesn't get through a lockout")
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+	var oauthErr OAuthError
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &oauthErr))
+	assert.Equal(t, "temporarily_unavailable", oauthErr.Code)
+}
+
+func TestRateLimits_ClientAuth(t *testing.T) {
+	h := newTestHandler()
+	rl := NewRateLimits()
+	handler := rl.ClientAuth(h.TokenEndpointHandler)
+	attempt := func(secret string) *httptest.ResponseRecorder {
+		form := clientCredentialsForm(secret)
+		req := httptest.NewRequest(http.MethodPost, TokenEndpointPath, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, attempt(ccClientSecret).Code)
+	for i := 0; i < ClientPolicy.MaxFailures; i++ {
+		assert.Equal(t, http.StatusUnauthorized, attempt("wrong").Code)
+	}
+
+	w := attempt(ccClientSecret)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	stats := rl.Clients.Stats()
+	if assert.Len(t, stats, 1) {
+		assert.Equal(t, ccClientId, stats[0].Key)
+		assert.Equal(t, ClientPolicy.MaxFailures, stats[0].Failures)
+		assert.NotNil(t, stats[0].LockedUntil)
+	}
+}
+
+// adminRequest calls the admin API with an access token for audience
+func adminRequest(rl *RateLimits, audience, scope string) *httptest.ResponseRecorder {
+	token, _ := auth.CreateJWT(nil, ccClientId, ccClientId, audience, scope)
+	req := httptest.NewRequest(http.MethodGet, AdminRateLimitsPath, nil)
+	req.Header.Set("Authorization", "Bearer "+token.Raw)
+	w := httptest.NewRecorder()
+	rl.AdminRateLimitsHandler(w, req)
+	return w
+}
+
+func TestRateLimits_AdminRateLimitsHandler(t *testing.T) {
+	rl := NewRateLimits()
+	rl.Users.Fail("jake")
+
+	w := adminRequest(rl, auth.Issuer, AdminScope)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp RateLimitsResponse
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	if assert.Len(t, resp.Users, 1) {
+		assert.Equal(t, "jake", resp.Users[0].Key)
+		assert.Equal(t, 1, resp.Users[0].Failures)
+	}
+
+	assert.Equal(t, http.StatusForbidden, adminRequest(rl, auth.Issuer, "api.read").Code)
+
+	req := httptest.NewRequest(http.MethodGet, AdminRateLimitsPath, nil)
+	w = httptest.NewRecorder()
+	rl.AdminRateLimitsHandler(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestRateLimits_AdminRateLimitsHandler_Audience(t *testing.T) {
+	// a token a client got for another resource, with the admin scope, isn't an admin token
+	w := adminRequest(NewRateLimits(), "https://api.example.com", AdminScope)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Bearer error="invalid_token"`, w.Header().Get("WWW-Authenticate"))
+}
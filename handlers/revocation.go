@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"JakeOAuth/auth"
+	"JakeOAuth/clients"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+)
+
+// RevocationHandler lets a client revoke a refresh or access token it was issued, see RFC 7009.
+// The client authenticates like it does at the token endpoint
+func (h *AuthHandler) RevocationHandler(w http.ResponseWriter, req *http.Request) {
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			log.Println("RevocationHandler: failed to close body")
+		}
+	}(req.Body)
+
+	oauthErr := h.revoke(req)
+	if oauthErr != nil {
+		log.Println("error: RevocationHandler:", oauthErr)
+		writeOAuthError(w, oauthErr, revocationErrorUri)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *AuthHandler) revoke(req *http.Request) *OAuthError {
+	err := req.ParseForm()
+	if err != nil {
+		return errInvalidRequest("The request body could not be parsed.").withCause(err)
+	}
+
+	formVals := req.Form
+	app, oauthErr := h.authenticateClient(formVals, req)
+	if oauthErr != nil {
+		return oauthErr
+	}
+
+	if !formVals.Has("token") {
+		return errInvalidRequest("The request is missing the token parameter.")
+	}
+	token := formVals.Get("token")
+
+	// like introspection the hint only decides what is looked up first (RFC 7009 section 2.1)
+	lookups := []func(clients.Client, string) (bool, *OAuthError){h.revokeRefreshToken, revokeAccessToken}
+	if formVals.Get("token_type_hint") == "access_token" {
+		lookups[0], lookups[1] = lookups[1], lookups[0]
+	}
+
+	for _, lookup := range lookups {
+		found, oauthErr := lookup(app, token)
+		if oauthErr != nil || found {
+			return oauthErr
+		}
+	}
+
+	// invalid tokens don't cause an error, there is nothing the client could do about it (RFC 7009 section 2.2)
+	log.Printf("client %s tried to revoke an unknown token\n", app.ClientId)
+	return nil
+}
+
+// revokeRefreshToken revokes the token's whole family, that's every token rotated from it,
+// and the access tokens issued for the same grant
+func (h *AuthHandler) revokeRefreshToken(app clients.Client, token string) (bool, *OAuthError) {
+	rt, err := h.TokenStore.Peek(token)
+	if errors.Is(err, auth.ErrRefreshTokenNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errServerError("Failed to look up refresh token.").withCause(err)
+	}
+	if rt.ClientId != app.ClientId {
+		return true, errUnauthorizedClient("The token was not issued to this client.").withCause(fmt.Errorf("client %s tried to revoke a refresh token of client %s", app.ClientId, rt.ClientId))
+	}
+
+	if err = h.TokenStore.RevokeFamily(rt.FamilyId); err != nil {
+		return true, errServerError("Failed to revoke refresh token.").withCause(err)
+	}
+	if err = auth.RevokedTokens.RevokeGrant(rt.FamilyId); err != nil {
+		return true, errServerError("Failed to revoke access tokens.").withCause(err)
+	}
+	log.Printf("client %s revoked refresh token %s and its grant %s\n", app.ClientId, rt.Id, rt.FamilyId)
+	return true, nil
+}
+
+// revokeAccessToken puts the token's jti on the denylist until it expires
+func revokeAccessToken(app clients.Client, token string) (bool, *OAuthError) {
+	claims, err := auth.ParseAccessToken(token, "")
+	if err != nil {
+		return false, nil
+	}
+	if claims.ClientId != app.ClientId {
+		return true, errUnauthorizedClient("The token was not issued to this client.").withCause(fmt.Errorf("client %s tried to revoke an access token of client %s", app.ClientId, claims.ClientId))
+	}
+
+	// a token without exp never expires, so neither does its denylist entry
+	exp := int64(math.MaxInt64)
+	if claims.ExpiresAt != nil {
+		exp = claims.ExpiresAt.Unix()
+	}
+	if err = auth.RevokedTokens.Revoke(claims.ID, exp); err != nil {
+		return true, errServerError("Failed to revoke access token.").withCause(err)
+	}
+	log.Printf("client %s revoked access token %s\n", app.ClientId, claims.ID)
+	return true, nil
+}
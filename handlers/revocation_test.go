@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"JakeOAuth/auth"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func revoke(h *AuthHandler, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, RevocationEndpointPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(ccClientId, ccClientSecret)
+	w := httptest.NewRecorder()
+	h.RevocationHandler(w, req)
+	return w
+}
+
+func TestRevocationHandler_AccessToken(t *testing.T) {
+	h := newTestHandler()
+	var tokenResp AccessTokenResponse
+	_ = json.Unmarshal(tokenRequest(h, clientCredentialsForm(ccClientSecret)).Body.Bytes(), &tokenResp)
+
+	w := revoke(h, url.Values{"token": {tokenResp.AccessToken}, "token_type_hint": {"access_token"}})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"active":false}`, introspect(h, url.Values{"token": {tokenResp.AccessToken}}).Body.String())
+}
+
+func TestRevocationHandler_RefreshTokenFamily(t *testing.T) {
+	h := newTestHandler()
+	rt, _ := h.TokenStore.Issue("grant", ccClientId, ccClientId, "api.read")
+	rotated, _ := h.TokenStore.Rotate(rt.Token, ccClientId)
+	token, _ := auth.CreateJWT(nil, ccClientId, ccClientId, auth.Issuer, "api.read")
+	claims := token.Claims.(auth.Claims)
+	auth.RevokedTokens.Track("grant", claims.ID, claims.ExpiresAt.Unix())
+
+	// revoking the old token takes its descendants and the grant's access tokens with it
+	w := revoke(h, url.Values{"token": {rt.Token}})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	_, err := h.TokenStore.Peek(rotated.Token)
+	assert.ErrorIs(t, err, auth.ErrRefreshTokenNotFound)
+	assert.Equal(t, `{"active":false}`, introspect(h, url.Values{"token": {token.Raw}}).Body.String())
+}
+
+func TestRevocationHandler_OtherClientsToken(t *testing.T) {
+	h := newTestHandler()
+	rt, _ := h.TokenStore.Issue("", "other-client", "", "")
+
+	w := revoke(h, url.Values{"token": {rt.Token}})
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	_, err := h.TokenStore.Peek(rt.Token)
+	assert.Nil(t, err)
+}
+
+func TestRevocationHandler_UnknownToken(t *testing.T) {
+	h := newTestHandler()
+
+	w := revoke(h, url.Values{"token": {"not-a-token"}})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"JakeOAuth/auth"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// sessionCookieName is the cookie with the id of the user's session
+const sessionCookieName = "jakeoauth_session"
+
+// currentSession returns the session of the request's session cookie, false when there is none or it timed out
+func (h *AuthHandler) currentSession(req *http.Request) (auth.Session, bool) {
+	cookie, err := req.Cookie(sessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return auth.Session{}, false
+	}
+	session, err := h.SessionStore.Get(cookie.Value)
+	if err != nil {
+		if !errors.Is(err, auth.ErrSessionNotFound) {
+			log.Println("error: failed to look up session:", err)
+		}
+		return auth.Session{}, false
+	}
+	return session, true
+}
+
+// startSession logs subject in with a new session. The old session is ended rather than reused, so a
+// session id someone else planted in the browser never becomes logged in (session fixation)
+func (h *AuthHandler) startSession(w http.ResponseWriter, req *http.Request, subject string) (*auth.Session, error) {
+	if cookie, err := req.Cookie(sessionCookieName); err == nil && cookie.Value != "" {
+		if err = h.SessionStore.Delete(cookie.Value); err != nil {
+			log.Println("error: failed to end the previous session:", err)
+		}
+	}
+
+	session, err := h.SessionStore.Create(subject)
+	if err != nil {
+		return nil, err
+	}
+	// SameSite has to be Lax, the browser comes back to the authorization endpoint from the client's
+	// site and a Strict cookie wouldn't be sent along. Browsers accept Secure cookies from http://localhost
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    session.Id,
+		Path:     "/",
+		Expires:  time.Unix(session.Exp, 0),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return session, nil
+}
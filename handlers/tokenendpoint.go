@@ -7,41 +7,40 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/golang-jwt/jwt/v5"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
-	"time"
+	"strings"
 )
 
-// TODO: make this not static...in a database or something
-var clientsSlice map[string]clients.Client
-var hasHeaderWritten = false
-var headerWrittenResponse = ""
-
-func init() {
-	clientsSlice = clients.ReadClients("clients/clients.json")
-}
-
 type AccessTokenResponse struct {
-	AccessToken   string `json:"access_token"`
-	TokenType     string `json:"token_type"`
-	ExpiresIn     int64  `json:"expires_in"`
-	RefreshToken  string `json:"refresh_token"`
-	TodoParameter string `json:"todo_parameter"`
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IdToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
 }
 
-func writeErrorResponse(w http.ResponseWriter, statusCode int, message string) {
-	hasHeaderWritten = true
-	headerWrittenResponse = message
-	w.WriteHeader(statusCode)
-	_, err := w.Write([]byte(message))
-	if err != nil {
-		fmt.Println("Error writing response:", err)
-	}
+// grantResult is what a grant handler hands back to TokenEndpointHandler to issue tokens for
+type grantResult struct {
+	// who and what the access token is issued for
+	subject  string
+	clientId string
+	scope    string
+	audience string
+	// the scope the client asked for, the granted scope is sent back if it is different (RFC 6749 section 5.1)
+	requestedScope string
+	refreshToken   string
+	// grantId links the access token to the grant so it's revoked along with it, empty for client credentials
+	grantId string
+	// code is set for the authorization code grant, it carries what's needed for the ID Token
+	code *auth.AuthorizationCode
 }
 
+// TokenEndpointHandler is the only place the token endpoint response gets written, the grant
+// handlers return either a result to issue tokens for or the error to respond with
 func (h *AuthHandler) TokenEndpointHandler(w http.ResponseWriter, req *http.Request) {
 	defer func(Body io.ReadCloser) {
 		err := Body.Close()
@@ -49,120 +48,312 @@ func (h *AuthHandler) TokenEndpointHandler(w http.ResponseWriter, req *http.Requ
 			log.Println("TokenEndpointHandler: failed to close body")
 		}
 	}(req.Body)
-	err := req.ParseForm()
+
+	resp, oauthErr := h.tokenResponse(req)
+	if oauthErr != nil {
+		log.Println("error: TokenEndpointHandler:", oauthErr)
+		writeOAuthError(w, oauthErr, tokenErrorUri)
+		return
+	}
+
+	bytes, err := json.Marshal(resp)
 	if err != nil {
-		log.Println("error: AuthorizationEndpointHandler, failed to parse form:", err)
-		writeErrorResponse(w, http.StatusUnauthorized, "unauthorized_client:The authorization server encountered an unexpected condition that prevented it from fulfilling the request.")
+		log.Println("error: TokenEndpointHandler failed to marshal access token response:", err)
+		writeOAuthError(w, errServerError("Failed to marshal access token response."), tokenErrorUri)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(bytes)
+
+	if err != nil {
+		fmt.Println("TokenEndpointHandler: error writing response:", err)
+	}
+	return
+}
+
+func (h *AuthHandler) tokenResponse(req *http.Request) (*AccessTokenResponse, *OAuthError) {
+	err := req.ParseForm()
+	if err != nil {
+		return nil, errInvalidRequest("The request body could not be parsed.").withCause(err)
+	}
+
 	formVals := req.Form
 	if !formVals.Has("grant_type") {
-		writeErrorResponse(w, http.StatusUnauthorized, "unauthorized_client:The client is not authorized to request an authorization code using this method.")
-		return
+		return nil, errInvalidRequest("The request is missing the grant_type parameter.")
 	}
 
 	grantType := formVals.Get("grant_type")
+	if !contains(grantTypesSupported, grantType) {
+		return nil, errUnsupportedGrantType("The authorization grant type is not supported by the authorization server.")
+	}
+
+	var result *grantResult
+	var oauthErr *OAuthError
 
 	switch grantType {
 	case "authorization_code":
-		handleAuthCodeGrantErr := handleAuthorizationCodeGrant(h, formVals, w)
-		if handleAuthCodeGrantErr != nil {
-			log.Println("error handling authorization code grant")
-			return
+		result, oauthErr = handleAuthorizationCodeGrant(h, formVals, req)
+	case "refresh_token":
+		result, oauthErr = handleRefreshTokenGrant(h, formVals, req)
+	case "client_credentials":
+		result, oauthErr = handleClientCredentialsGrant(h, formVals, req)
+	default:
+		oauthErr = errUnsupportedGrantType("The authorization grant type is not supported by the authorization server.")
+	}
+	if oauthErr != nil {
+		return nil, oauthErr
+	}
+
+	return h.issueTokens(result)
+}
+
+// audience is who the access token is for, the resource parameter if the client may ask for it (RFC 8707
+// section 2) and this server otherwise. The grants check it once app is authenticated, but before the
+// code is redeemed or the refresh token rotated, those can't be undone when the resource is rejected
+func audience(formVals url.Values, app clients.Client) (string, *OAuthError) {
+	resources := formVals["resource"]
+	if len(resources) == 0 {
+		return auth.Issuer, nil
+	}
+	if len(resources) > 1 {
+		return "", errInvalidTarget("Only one resource can be requested at a time.")
+	}
+
+	resource := resources[0]
+	if resource == auth.Issuer {
+		return resource, nil
+	}
+	u, err := url.Parse(resource)
+	if err != nil || !u.IsAbs() || u.Fragment != "" {
+		return "", errInvalidTarget("The resource has to be an absolute URI without a fragment.").withCause(fmt.Errorf("invalid resource `%s`", resource))
+	}
+	if !app.AllowsResource(resource) {
+		return "", errInvalidTarget("The client is not allowed to request tokens for the resource.").withCause(fmt.Errorf("client %s is not allowed resource %s", app.ClientId, resource))
+	}
+	return resource, nil
+}
+
+// issueTokens creates the access token, and ID Token if openid was granted, for a successful grant
+func (h *AuthHandler) issueTokens(result *grantResult) (*AccessTokenResponse, *OAuthError) {
+	token, err := auth.CreateJWT(auth.Keys.DefaultMethod(), result.subject, result.clientId, result.audience, result.scope)
+	if err != nil {
+		return nil, errServerError("Failed to create access token.").withCause(err)
+	}
+	if result.grantId != "" {
+		claims := token.Claims.(auth.Claims)
+		if err = auth.RevokedTokens.Track(result.grantId, claims.ID, claims.ExpiresAt.Unix()); err != nil {
+			return nil, errServerError("Failed to record access token.").withCause(err)
 		}
+	}
 
-	case "client_credentials":
-		handleCcErr := handleClientCredentialsGrant(formVals, req, w)
-		if handleCcErr != nil {
-			log.Println("error handling client credentials grant:", err)
-			return
+	idToken := ""
+	if result.code != nil && hasScope(result.scope, "openid") {
+		client, err := h.ClientStore.Client(result.clientId)
+		if err != nil {
+			return nil, errServerError("Failed to look up client.").withCause(err)
 		}
-	default:
-		writeErrorResponse(w, http.StatusBadRequest, "unauthorized_client:The authorization server encountered an unexpected condition that prevented it from fulfilling the request.")
-		return
+		idJwt, err := auth.CreateIDToken(result.code, token.Raw, client.IdTokenAlg())
+		if err != nil {
+			return nil, errServerError("Failed to create id token.").withCause(err)
+		}
+		idToken = idJwt.Raw
 	}
 
-	token, createJwtErr := auth.CreateJWT(jwt.SigningMethodRS256, "")
-	if createJwtErr != nil {
-		log.Println("error: TokenEndpointHandler failed to create jwt:", createJwtErr)
-		writeErrorResponse(w, http.StatusUnauthorized, "unauthorized_client:The authorization server encountered an unexpected condition that prevented it from fulfilling the request.")
-		return
+	grantedScope := ""
+	if !auth.SameScope(result.scope, result.requestedScope) {
+		grantedScope = result.scope
 	}
 
-	resp := &AccessTokenResponse{
-		AccessToken:   token.Raw,
-		TokenType:     "Bearer",
-		ExpiresIn:     time.Now().Add(auth.CodeExpiration).Unix(),
-		RefreshToken:  "TODO",
-		TodoParameter: "TODO",
+	return &AccessTokenResponse{
+		AccessToken:  token.Raw,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(auth.AccessTokenExpiration.Seconds()),
+		RefreshToken: result.refreshToken,
+		IdToken:      idToken,
+		Scope:        grantedScope,
+	}, nil
+}
+
+func handleAuthorizationCodeGrant(h *AuthHandler, formVals url.Values, req *http.Request) (*grantResult, *OAuthError) {
+	if !formVals.Has("code_verifier") || !formVals.Has("code") {
+		return nil, errInvalidRequest("The request is missing the code or code_verifier parameter.")
 	}
 
-	bytes, marshalErr := json.Marshal(resp)
-	if marshalErr != nil {
-		log.Println("error: TokenEndpointHandler failed to marshal access token response:", marshalErr)
-		writeErrorResponse(w, http.StatusInternalServerError, "internal_server_error:Failed to marshal access token response.")
-		return
+	// a confidential client has to authenticate, a stolen code and verifier aren't enough (RFC 6749 section 4.1.3)
+	app, oauthErr := h.identifyClient(formVals, req)
+	if oauthErr != nil {
+		return nil, oauthErr
+	}
+	aud, oauthErr := audience(formVals, app)
+	if oauthErr != nil {
+		return nil, oauthErr
 	}
 
-	if hasHeaderWritten {
-		log.Println("WARNING!!! HEADER HAS ALREADY BEEN WRITTEN!!!!")
-		log.Println(hasHeaderWritten)
-		log.Println(headerWrittenResponse)
+	code, err := h.CodeStore.Redeem(formVals.Get("code"), formVals.Get("code_verifier"), app.ClientId, formVals.Get("redirect_uri"))
+	if errors.Is(err, auth.ErrCodeReplayed) {
+		log.Printf("authorization code replayed, revoking tokens issued for grant %s\n", code.GrantId)
+		if revokeErr := h.TokenStore.RevokeFamily(code.GrantId); revokeErr != nil {
+			log.Println("error: failed to revoke refresh tokens of replayed code:", revokeErr)
+		}
+		if revokeErr := auth.RevokedTokens.RevokeGrant(code.GrantId); revokeErr != nil {
+			log.Println("error: failed to revoke access tokens of replayed code:", revokeErr)
+		}
+		return nil, errInvalidGrant("The provided authorization code has already been used.").withCause(err)
+	}
+	if err != nil {
+		return nil, errInvalidGrant("The provided authorization code is invalid, expired, or was issued to another client.").withCause(err)
 	}
-	w.WriteHeader(http.StatusOK)
-	_, err = w.Write(bytes)
 
+	rt, err := h.TokenStore.Issue(code.GrantId, code.ClientId, code.Subject, code.Scope)
 	if err != nil {
-		fmt.Println("TokenEndpointHandler: error writing response:", err)
+		return nil, errServerError("Failed to issue refresh token.").withCause(err)
 	}
-	return
+
+	return &grantResult{
+		subject:        code.Subject,
+		clientId:       code.ClientId,
+		scope:          code.Scope,
+		audience:       aud,
+		requestedScope: code.RequestedScope,
+		refreshToken:   rt.Token,
+		grantId:        code.GrantId,
+		code:           code,
+	}, nil
 }
 
-func handleAuthorizationCodeGrant(h *AuthHandler, formVals url.Values, w http.ResponseWriter) error {
-	if !formVals.Has("code_verifier") || !formVals.Has("code") {
-		writeErrorResponse(w, http.StatusUnauthorized, "code verifier or code does not exist in request")
-		return errors.New("code verifier or code does not exist in request")
+func handleRefreshTokenGrant(h *AuthHandler, formVals url.Values, req *http.Request) (*grantResult, *OAuthError) {
+	if !formVals.Has("refresh_token") {
+		return nil, errInvalidRequest("The request is missing the refresh_token parameter.")
+	}
+
+	app, oauthErr := h.identifyClient(formVals, req)
+	if oauthErr != nil {
+		return nil, oauthErr
+	}
+	clientId := app.ClientId
+	aud, oauthErr := audience(formVals, app)
+	if oauthErr != nil {
+		return nil, oauthErr
 	}
 
-	if isValid, err := h.CodeStore.CheckTokenWithPkce(formVals.Get("code"), formVals.Get("code_verifier")); !isValid {
+	// the scope has to be checked before rotating, otherwise the client would lose its refresh token
+	// an unknown token is left to Rotate, which says it's an invalid grant
+	scope := ""
+	original, err := h.TokenStore.Peek(formVals.Get("refresh_token"))
+	if err != nil && !errors.Is(err, auth.ErrRefreshTokenNotFound) {
+		return nil, errServerError("Failed to look up refresh token.").withCause(err)
+	}
+	if err == nil {
+		scope, err = auth.NarrowScope(formVals.Get("scope"), original.Scope)
 		if err != nil {
-			log.Println("error while checking token with pkce:", err)
+			return nil, errInvalidScope("The requested scope exceeds the scope originally granted by the resource owner.").withCause(err)
 		}
-		writeErrorResponse(w, http.StatusUnauthorized, "unauthorized_client:The authorization server encountered an unexpected condition that prevented it from fulfilling the request.")
-		return errors.New("code and code verifier do not match")
 	}
-	return nil
+
+	rt, err := h.TokenStore.Rotate(formVals.Get("refresh_token"), clientId)
+	if err != nil {
+		return nil, errInvalidGrant("The provided refresh token is invalid, expired, revoked or was issued to another client.").withCause(err)
+	}
+
+	return &grantResult{
+		subject:        rt.Subject,
+		clientId:       rt.ClientId,
+		scope:          scope,
+		audience:       aud,
+		requestedScope: formVals.Get("scope"),
+		refreshToken:   rt.Token,
+		grantId:        rt.FamilyId,
+	}, nil
 }
 
-func handleClientCredentialsGrant(formVals url.Values, req *http.Request, w http.ResponseWriter) error {
+func handleClientCredentialsGrant(h *AuthHandler, formVals url.Values, req *http.Request) (*grantResult, *OAuthError) {
+	app, oauthErr := h.authenticateClient(formVals, req)
+	if oauthErr != nil {
+		return nil, oauthErr
+	}
+	clientId := app.ClientId
+
+	if app.Type != "client_credentials" {
+		return nil, errUnauthorizedClient("The client is not authorized to use the client_credentials grant type.").withCause(fmt.Errorf("client %s is not a client_credentials client", clientId))
+	}
+
+	scope, err := auth.GrantScope(formVals.Get("scope"), app.AllowedScopes)
+	if err != nil {
+		return nil, errInvalidScope("The requested scope is invalid, unknown, malformed, or exceeds the scope granted to the client.").withCause(err)
+	}
+	aud, oauthErr := audience(formVals, app)
+	if oauthErr != nil {
+		return nil, oauthErr
+	}
+
+	// the client is acting on its own behalf so it is the subject (RFC 9068 section 2.2)
+	return &grantResult{
+		subject:        clientId,
+		clientId:       clientId,
+		scope:          scope,
+		audience:       aud,
+		requestedScope: formVals.Get("scope"),
+	}, nil
+}
+
+// authenticateClient checks the client credentials sent with client_secret_basic or client_secret_post
+// (RFC 6749 section 2.3.1) and returns the client they belong to
+func (h *AuthHandler) authenticateClient(formVals url.Values, req *http.Request) (clients.Client, *OAuthError) {
 	var clientId, clientSecret string
 
 	if header := req.Header.Get("Authorization"); header != "" {
 		var err error
 		clientId, clientSecret, err = util.DecodeBasicAuth(header)
 		if err != nil {
-			writeErrorResponse(w, http.StatusUnauthorized, "unauthorized_client:The authorization server encountered an unexpected condition that prevented it from fulfilling the request.")
-			return err
+			return clients.Client{}, errInvalidClient("The Authorization header could not be decoded.").withCause(err)
 		}
 	} else {
 		if !formVals.Has("client_secret") {
-			writeErrorResponse(w, http.StatusUnauthorized, "unauthorized_client:The client is not authorized to request an authorization code using this method.")
-			return fmt.Errorf("client_secret param was not included in request")
+			return clients.Client{}, errInvalidClient("The request is missing client authentication.").withCause(errors.New("client_secret param was not included in request"))
 		}
 		clientId = formVals.Get("client_id")
 		clientSecret = formVals.Get("client_secret")
 	}
 
-	if app, ok := clientsSlice[clientId]; ok {
-		if app.ClientSecret != clientSecret {
-			writeErrorResponse(w, http.StatusUnauthorized, "unauthorized_client:The client credentials grant did not have the correct secret.")
-			return fmt.Errorf("client credentials grant did not have the correct secret")
-		}
-	} else {
-		writeErrorResponse(w, http.StatusUnauthorized, "unauthorized_client:The client is not authorized to request an authorization code using this method.")
-		return fmt.Errorf("client id not matched or something went wrong %s", clientId)
+	app, err := h.ClientStore.Client(clientId)
+	if errors.Is(err, clients.ErrClientNotFound) {
+		return clients.Client{}, errInvalidClient("Client authentication failed.").withCause(fmt.Errorf("client id not matched or something went wrong %s", clientId))
 	}
+	if err != nil {
+		return clients.Client{}, errServerError("Failed to look up client.").withCause(err)
+	}
+	if !app.VerifySecret(clientSecret) {
+		return clients.Client{}, errInvalidClient("Client authentication failed.").withCause(fmt.Errorf("client %s did not have the correct secret", clientId))
+	}
+	return app, nil
+}
+
+// identifyClient returns the client making the request. Confidential clients have to authenticate
+// (RFC 6749 section 3.2.1), a public client only identifies itself with client_id
+func (h *AuthHandler) identifyClient(formVals url.Values, req *http.Request) (clients.Client, *OAuthError) {
+	if req.Header.Get("Authorization") != "" || formVals.Has("client_secret") {
+		return h.authenticateClient(formVals, req)
+	}
+
+	clientId := formVals.Get("client_id")
+	app, err := h.ClientStore.Client(clientId)
+	if errors.Is(err, clients.ErrClientNotFound) {
+		return clients.Client{}, errInvalidClient("Client authentication failed.").withCause(fmt.Errorf("client id not matched or something went wrong %s", clientId))
+	}
+	if err != nil {
+		return clients.Client{}, errServerError("Failed to look up client.").withCause(err)
+	}
+	if app.Confidential() {
+		return clients.Client{}, errInvalidClient("The request is missing client authentication.").withCause(fmt.Errorf("confidential client %s did not authenticate", clientId))
+	}
+	return app, nil
+}
 
-	return nil
+// hasScope checks if s is one of the space delimited scopes in scope
+func hasScope(scope, s string) bool {
+	return contains(strings.Fields(scope), s)
 }
@@ -0,0 +1,528 @@
+package handlers
+
+import (
+	"JakeOAuth/auth"
+	"JakeOAuth/clients"
+	"JakeOAuth/storage"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	ccClientId     = "d3200efd-c8a1-4f90-a056-cf22b714a0fc"
+	ccClientSecret = "ddccd2d0-2b80-4ee8-ab33-beaa5fcb89fe"
+	acClientSecret = "71a0e768-0a57-4c2e-9d72-421d8bf3ca63"
+)
+
+var testClients = clients.ReadClients("../clients/clients.json")
+
+var testUsers *clients.UserFile
+
+func init() {
+	var err error
+	auth.Keys, err = auth.LoadKeyring("../keys/private.pem")
+	if err != nil {
+		panic(err)
+	}
+	auth.Issuer = "http://localhost:8080"
+	dir, err := os.MkdirTemp("", "handlers")
+	if err != nil {
+		panic(err)
+	}
+	testUsers, err = openTestUsers(filepath.Join(dir, "users.json"), `{"jake": {"password": "password"}}`)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// openTestUsers writes contents to filename and opens it, users.json itself is never touched by the tests
+func openTestUsers(filename, contents string) (*clients.UserFile, error) {
+	if err := os.WriteFile(filename, []byte(contents), 0600); err != nil {
+		return nil, err
+	}
+	return clients.OpenUserFile(filename)
+}
+
+func newTestHandler() *AuthHandler {
+	return NewAuthHandler(auth.NewAuthCodeStore(), auth.NewRefreshTokenStore(), testClients, testUsers, auth.NewSessionStore())
+}
+
+func tokenRequest(h *AuthHandler, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, TokenEndpointPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h.TokenEndpointHandler(w, req)
+	return w
+}
+
+// basicTokenRequest is a tokenRequest authenticated with client_secret_basic
+func basicTokenRequest(h *AuthHandler, form url.Values, clientId, secret string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, TokenEndpointPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(clientId, secret)
+	w := httptest.NewRecorder()
+	h.TokenEndpointHandler(w, req)
+	return w
+}
+
+func clientCredentialsForm(secret string) url.Values {
+	return url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {ccClientId},
+		"client_secret": {secret},
+	}
+}
+
+func TestTokenEndpointHandler_ClientCredentials(t *testing.T) {
+	h := newTestHandler()
+
+	w := tokenRequest(h, clientCredentialsForm(ccClientSecret))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	var resp AccessTokenResponse
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "Bearer", resp.TokenType)
+	assert.Empty(t, resp.RefreshToken)
+	valid, err := auth.ValidateToken(resp.AccessToken)
+	assert.Nil(t, err)
+	assert.True(t, valid)
+}
+
+func TestTokenEndpointHandler_Errors(t *testing.T) {
+	h := newTestHandler()
+
+	var tests = []struct {
+		name       string
+		form       url.Values
+		statusCode int
+		errorCode  string
+	}{
+		{"missing grant_type", url.Values{}, http.StatusBadRequest, "invalid_request"},
+		{"unsupported grant_type", url.Values{"grant_type": {"password"}}, http.StatusBadRequest, "unsupported_grant_type"},
+		{"wrong secret", clientCredentialsForm("wrong"), http.StatusUnauthorized, "invalid_client"},
+		{"invalid scope", url.Values{
+			"grant_type":    {"client_credentials"},
+			"client_id":     {ccClientId},
+			"client_secret": {ccClientSecret},
+			"scope":         {"openid"},
+		}, http.StatusBadRequest, "invalid_scope"},
+		{"unknown code", url.Values{
+			"grant_type":    {"authorization_code"},
+			"client_id":     {ccClientId},
+			"client_secret": {ccClientSecret},
+			"code":          {"nope"},
+			"code_verifier": {"nope"},
+		}, http.StatusBadRequest, "invalid_grant"},
+	}
+
+	for _, tt := range tests {
+		w := tokenRequest(h, tt.form)
+
+		assert.Equalf(t, tt.statusCode, w.Code, "[%s] wrong status code", tt.name)
+		var oauthErr OAuthError
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &oauthErr))
+		assert.Equalf(t, tt.errorCode, oauthErr.Code, "[%s] wrong error code", tt.name)
+	}
+}
+
+func TestTokenEndpointHandler_RotatedSecret(t *testing.T) {
+	h := newTestHandler()
+	rotated := clients.Clients{}
+	for id, c := range testClients {
+		rotated[id] = c
+	}
+	c := rotated[ccClientId]
+	c.ClientSecrets = []clients.ClientSecret{
+		{Hash: clients.HashClientSecret(ccClientSecret), ExpiresAt: time.Now().Add(-time.Minute).Unix()},
+		{Hash: clients.HashClientSecret("new secret")},
+	}
+	rotated[ccClientId] = c
+	h.ClientStore = rotated
+
+	assert.Equal(t, http.StatusUnauthorized, tokenRequest(h, clientCredentialsForm(ccClientSecret)).Code)
+	assert.Equal(t, http.StatusOK, tokenRequest(h, clientCredentialsForm("new secret")).Code)
+}
+
+// TestTokenEndpointHandler_Parallel is meant to be run with -race, every response has to
+// match its own request no matter what the other requests did
+func TestTokenEndpointHandler_Parallel(t *testing.T) {
+	h := newTestHandler()
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(succeed bool) {
+			defer wg.Done()
+			secret := "wrong"
+			expected := http.StatusUnauthorized
+			if succeed {
+				secret = ccClientSecret
+				expected = http.StatusOK
+			}
+
+			w := tokenRequest(h, clientCredentialsForm(secret))
+
+			assert.Equal(t, expected, w.Code)
+			if succeed {
+				var resp AccessTokenResponse
+				assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+				assert.NotEmpty(t, resp.AccessToken)
+			}
+		}(i%2 == 0)
+	}
+	wg.Wait()
+}
+
+func TestTokenEndpointHandler_RefreshToken(t *testing.T) {
+	h := newTestHandler()
+	rt, _ := h.TokenStore.Issue("grant", acClientId, "jake", "openid api.read")
+
+	w := tokenRequest(h, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {rt.Token},
+		"client_id":     {acClientId},
+		"client_secret": {acClientSecret},
+	})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp AccessTokenResponse
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.NotEmpty(t, resp.RefreshToken)
+
+	w = basicTokenRequest(h, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {resp.RefreshToken}}, acClientId, acClientSecret)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestTokenEndpointHandler_RefreshToken_ClientAuthentication(t *testing.T) {
+	h := newTestHandler()
+	rt, _ := h.TokenStore.Issue("grant", acClientId, "jake", "openid api.read")
+	form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {rt.Token}, "client_id": {acClientId}}
+
+	var tests = []struct {
+		name string
+		do   func() *httptest.ResponseRecorder
+	}{
+		{"missing secret", func() *httptest.ResponseRecorder { return tokenRequest(h, form) }},
+		{"wrong client_secret", func() *httptest.ResponseRecorder {
+			wrong := url.Values{"client_secret": {"wrong"}}
+			for k, v := range form {
+				wrong[k] = v
+			}
+			return tokenRequest(h, wrong)
+		}},
+		{"wrong basic secret", func() *httptest.ResponseRecorder {
+			return basicTokenRequest(h, form, acClientId, "wrong")
+		}},
+		{"unknown client", func() *httptest.ResponseRecorder {
+			return tokenRequest(h, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {rt.Token}, "client_id": {"nope"}})
+		}},
+	}
+
+	for _, tt := range tests {
+		w := tt.do()
+
+		assert.Equalf(t, http.StatusUnauthorized, w.Code, "[%s] wrong status code", tt.name)
+		var oauthErr OAuthError
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &oauthErr))
+		assert.Equalf(t, "invalid_client", oauthErr.Code, "[%s] wrong error code", tt.name)
+	}
+
+	// the refresh token wasn't rotated by any of them
+	stored, err := h.TokenStore.Peek(rt.Token)
+	assert.Nil(t, err)
+	assert.True(t, stored.Active())
+}
+
+func TestTokenEndpointHandler_RefreshToken_PublicClient(t *testing.T) {
+	h := newTestHandler()
+	h.ClientStore = clients.Clients{"public": {ClientId: "public", Type: "authorization_code"}}
+	rt, _ := h.TokenStore.Issue("grant", "public", "jake", "openid")
+
+	w := tokenRequest(h, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {rt.Token}, "client_id": {"public"}})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestTokenEndpointHandler_IDToken(t *testing.T) {
+	h := newTestHandler()
+	b := newTestBrowser()
+	b.login(t, h, newTestRequest(t, h), "jake", "password")
+	session, _ := h.SessionStore.Get(b.cookies[sessionCookieName].Value)
+	query := b.authorizeWithSession(t, h, withParams(url.Values{"nonce": {"n-0S6_WzA2Mj"}}))
+
+	w := tokenRequest(h, url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {acClientId},
+		"client_secret": {acClientSecret},
+		"code":          {query.Get("code")},
+		"code_verifier": {"verifier"},
+	})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp AccessTokenResponse
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	if !assert.NotEmpty(t, resp.IdToken) {
+		return
+	}
+	idToken, err := auth.ParseToken(resp.IdToken)
+	if !assert.Nil(t, err) {
+		return
+	}
+	claims := idToken.Claims.(jwt.MapClaims)
+	assert.Equal(t, "jake", claims["sub"])
+	assert.Equal(t, auth.Issuer, claims["iss"])
+	assert.Equal(t, []any{acClientId}, claims["aud"])
+	assert.Equal(t, "n-0S6_WzA2Mj", claims["nonce"])
+	assert.Equal(t, float64(session.AuthTime), claims["auth_time"])
+	// at_hash is the left half of the access token's SHA-256 for RS256
+	sum := sha256.Sum256([]byte(resp.AccessToken))
+	assert.Equal(t, base64.RawURLEncoding.EncodeToString(sum[:16]), claims["at_hash"])
+}
+
+func TestTokenEndpointHandler_NoIDTokenWithoutOpenid(t *testing.T) {
+	h := newTestHandler()
+	b := newTestBrowser()
+	b.login(t, h, newTestRequest(t, h), "jake", "password")
+	query := b.authorizeWithSession(t, h, withParams(url.Values{"scope": {"api.read"}}))
+
+	w := tokenRequest(h, url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {acClientId},
+		"client_secret": {acClientSecret},
+		"code":          {query.Get("code")},
+		"code_verifier": {"verifier"},
+	})
+
+	var resp AccessTokenResponse
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.NotEmpty(t, resp.AccessToken)
+	assert.Empty(t, resp.IdToken)
+}
+
+func TestTokenEndpointHandler_Resource(t *testing.T) {
+	h := newTestHandler()
+	withResources := clients.Clients{}
+	for id, c := range testClients {
+		c.AllowedResources = []string{"https://api.example.com"}
+		withResources[id] = c
+	}
+	h.ClientStore = withResources
+
+	var tests = []struct {
+		name     string
+		resource []string
+		audience string
+		error    string
+	}{
+		{"no resource", nil, auth.Issuer, ""},
+		{"this server", []string{auth.Issuer}, auth.Issuer, ""},
+		{"allowed", []string{"https://api.example.com"}, "https://api.example.com", ""},
+		{"not allowed", []string{"https://evil.example.com"}, "", "invalid_target"},
+		{"not absolute", []string{"api.example.com"}, "", "invalid_target"},
+		{"fragment", []string{"https://api.example.com#a"}, "", "invalid_target"},
+		{"several", []string{"https://api.example.com", auth.Issuer}, "", "invalid_target"},
+	}
+
+	for _, tt := range tests {
+		form := clientCredentialsForm(ccClientSecret)
+		form["resource"] = tt.resource
+
+		w := tokenRequest(h, form)
+
+		if tt.error != "" {
+			assert.Equalf(t, http.StatusBadRequest, w.Code, "[%s] wrong status code", tt.name)
+			var oauthErr OAuthError
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &oauthErr))
+			assert.Equalf(t, tt.error, oauthErr.Code, "[%s] wrong error code", tt.name)
+			continue
+		}
+		var resp AccessTokenResponse
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		claims, err := auth.ParseAccessToken(resp.AccessToken, "")
+		if assert.Nilf(t, err, "[%s] no access token", tt.name) {
+			assert.Equalf(t, []string{tt.audience}, []string(claims.Audience), "[%s] wrong audience", tt.name)
+		}
+	}
+}
+
+func TestTokenEndpointHandler_Resource_KeepsRefreshToken(t *testing.T) {
+	h := newTestHandler()
+	rt, _ := h.TokenStore.Issue("grant", acClientId, "jake", "openid api.read")
+
+	w := tokenRequest(h, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {rt.Token},
+		"client_id":     {acClientId},
+		"client_secret": {acClientSecret},
+		"resource":      {"https://evil.example.com"},
+	})
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	stored, err := h.TokenStore.Peek(rt.Token)
+	assert.Nil(t, err)
+	assert.True(t, stored.Active(), "the refresh token was rotated for a rejected request")
+}
+
+func TestTokenEndpointHandler_Resource_AfterClientAuthentication(t *testing.T) {
+	h := newTestHandler()
+	rt, _ := h.TokenStore.Issue("grant", acClientId, "jake", "openid api.read")
+
+	// without authenticating, whether the client exists or may use the resource doesn't show
+	var tests = []struct {
+		name     string
+		clientId string
+		resource string
+	}{
+		{"unknown client", "nope", "https://api.example.com"},
+		{"unknown client, other resource", "nope", "https://evil.example.com"},
+		{"known client", acClientId, "https://api.example.com"},
+		{"known client, other resource", acClientId, "https://evil.example.com"},
+	}
+
+	for _, tt := range tests {
+		w := tokenRequest(h, url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {rt.Token},
+			"client_id":     {tt.clientId},
+			"resource":      {tt.resource},
+		})
+
+		assert.Equalf(t, http.StatusUnauthorized, w.Code, "[%s] wrong status code", tt.name)
+		var oauthErr OAuthError
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &oauthErr))
+		assert.Equalf(t, "invalid_client", oauthErr.Code, "[%s] wrong error code", tt.name)
+	}
+}
+
+func TestTokenEndpointHandler_Resource_KeepsCode(t *testing.T) {
+	h := newTestHandler()
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {newTestCode(t, h)},
+		"code_verifier": {"verifier"},
+		"resource":      {"https://evil.example.com"},
+	}
+
+	w := basicTokenRequest(h, form, acClientId, acClientSecret)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var oauthErr OAuthError
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &oauthErr))
+	assert.Equal(t, "invalid_target", oauthErr.Code)
+
+	form.Set("resource", auth.Issuer)
+	assert.Equal(t, http.StatusOK, basicTokenRequest(h, form, acClientId, acClientSecret).Code, "the code was redeemed for a rejected request")
+}
+
+func TestTokenEndpointHandler_ResponseFields(t *testing.T) {
+	w := tokenRequest(newTestHandler(), clientCredentialsForm(ccClientSecret))
+
+	var fields map[string]any
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &fields))
+	for field := range fields {
+		assert.Contains(t, []string{"access_token", "token_type", "expires_in", "refresh_token", "id_token", "scope"}, field)
+	}
+}
+
+// failingPeekStore is a TokenStore that can't look tokens up
+type failingPeekStore struct {
+	storage.TokenStore
+	rotated bool
+}
+
+func (s *failingPeekStore) Peek(string) (auth.RefreshToken, error) {
+	return auth.RefreshToken{}, errors.New("database is locked")
+}
+
+func (s *failingPeekStore) Rotate(token, clientId string) (*auth.RefreshToken, error) {
+	s.rotated = true
+	return s.TokenStore.Rotate(token, clientId)
+}
+
+func TestTokenEndpointHandler_RefreshToken_PeekError(t *testing.T) {
+	h := newTestHandler()
+	rt, _ := h.TokenStore.Issue("grant", acClientId, "jake", "openid api.read")
+	store := &failingPeekStore{TokenStore: h.TokenStore}
+	h.TokenStore = store
+
+	w := basicTokenRequest(h, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {rt.Token}}, acClientId, acClientSecret)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.False(t, store.rotated, "the token was rotated without knowing its scope")
+}
+
+// newTestCode logs jake in and returns a code issued to acClientId with the verifier "verifier"
+func newTestCode(t *testing.T, h *AuthHandler) string {
+	b := newTestBrowser()
+	b.login(t, h, newTestRequest(t, h), "jake", "password")
+	return b.authorizeWithSession(t, h, authorizeParams).Get("code")
+}
+
+func TestTokenEndpointHandler_AuthorizationCode_BasicAuth(t *testing.T) {
+	h := newTestHandler()
+	code := newTestCode(t, h)
+
+	// the client_id is only in the Authorization header
+	w := basicTokenRequest(h, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"code_verifier": {"verifier"},
+	}, acClientId, acClientSecret)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestTokenEndpointHandler_AuthorizationCode_ClientAuthentication(t *testing.T) {
+	h := newTestHandler()
+	code := newTestCode(t, h)
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {acClientId},
+		"code":          {code},
+		"code_verifier": {"verifier"},
+	}
+
+	var tests = []struct {
+		name string
+		do   func() *httptest.ResponseRecorder
+	}{
+		{"missing secret", func() *httptest.ResponseRecorder { return tokenRequest(h, form) }},
+		{"wrong client_secret", func() *httptest.ResponseRecorder {
+			wrong := url.Values{"client_secret": {"wrong"}}
+			for k, v := range form {
+				wrong[k] = v
+			}
+			return tokenRequest(h, wrong)
+		}},
+		{"wrong basic secret", func() *httptest.ResponseRecorder {
+			return basicTokenRequest(h, form, acClientId, "wrong")
+		}},
+	}
+
+	for _, tt := range tests {
+		w := tt.do()
+
+		assert.Equalf(t, http.StatusUnauthorized, w.Code, "[%s] wrong status code", tt.name)
+		var oauthErr OAuthError
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &oauthErr))
+		assert.Equalf(t, "invalid_client", oauthErr.Code, "[%s] wrong error code", tt.name)
+	}
+
+	// none of them used up the code
+	assert.Equal(t, http.StatusOK, basicTokenRequest(h, form, acClientId, acClientSecret).Code)
+}
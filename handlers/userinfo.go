@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"JakeOAuth/auth"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+type UserInfoResponse struct {
+	Subject string `json:"sub"`
+}
+
+// UserInfoHandler returns claims about the user the bearer access token was issued for,
+// see https://openid.net/specs/openid-connect-core-1_0.html#UserInfo
+func UserInfoHandler(w http.ResponseWriter, req *http.Request) {
+	tokenString, found := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !found || tokenString == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// the UserInfo endpoint is a resource of the issuer, a token for another resource can't be used here
+	claims, err := auth.ParseAccessToken(tokenString, auth.Issuer)
+	if err != nil {
+		log.Println("error: UserInfoHandler failed to validate access token:", err)
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	bytes, err := json.Marshal(&UserInfoResponse{Subject: claims.Subject})
+	if err != nil {
+		log.Println("error: UserInfoHandler failed to marshal response:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(bytes)
+	if err != nil {
+		log.Println("UserInfoHandler: error writing response:", err)
+	}
+}
@@ -2,11 +2,16 @@ package main
 
 import (
 	"JakeOAuth/auth"
-	"JakeOAuth/handlers"
 	"bytes"
+	"context"
+	"flag"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 type JakeHandler struct{}
@@ -27,13 +32,31 @@ func (lm *LoggingMiddleware) log(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// Log the body content
-	log.Printf("Body: %s\n", string(body))
+	// Log the body content, without any credentials in it
+	log.Printf("Body: %s\n", redactBody(body))
 
 	// Restore the body so it can be read again in the handler
 	req.Body = io.NopCloser(bytes.NewBuffer(body))
 }
 
+// sensitiveParams are the form parameters that carry credentials, only their fingerprint is logged
+var sensitiveParams = []string{"code", "code_verifier", "refresh_token", "token", "client_secret", "password", "csrf_token"}
+
+// redactBody replaces every credential in a form encoded body with its fingerprint, the same one
+// the stores log for a code or refresh token so requests can still be matched up with them
+func redactBody(body []byte) string {
+	vals, err := url.ParseQuery(string(body))
+	if err != nil {
+		return "<body is not form encoded>"
+	}
+	for _, p := range sensitiveParams {
+		for i, v := range vals[p] {
+			vals[p][i] = "fingerprint:" + auth.Fingerprint(auth.HashToken(v))
+		}
+	}
+	return vals.Encode()
+}
+
 func Handlers(handler func(w http.ResponseWriter, req *http.Request)) {
 	h := handler
 	h(nil, nil)
@@ -60,15 +83,6 @@ type GetHandler struct {
 }
 
 func (gh *GetHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:5173")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-	// Handle preflight (OPTIONS) request
-	if req.Method == http.MethodOptions {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
-
 	if req.Method != http.MethodGet {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
@@ -79,35 +93,68 @@ func (gh *GetHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	return
 }
 
+// PageHandler serves the login and consent pages, GET renders the page and POST submits its form
+type PageHandler struct {
+	Handler    func(w http.ResponseWriter, req *http.Request)
+	Middleware LoggingMiddleware
+}
+
+func (ph *PageHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	ph.Middleware.log(w, req)
+	ph.Handler(w, req)
+	return
+}
+
 func main() {
-	h := handlers.NewAuthHandler(auth.NewAuthCodeStore())
-	go h.CodeStore.ListenExpiration()
-	authHandler := &GetHandler{
-		Handler:    h.AuthorizationEndpointHandler,
-		Middleware: LoggingMiddleware{},
+	dbPath := flag.String("db", "", "SQLite database to keep codes, tokens, clients and users in, everything is kept in memory when empty")
+	addr := flag.String("addr", "localhost:8080", "address to listen on")
+	issuer := flag.String("issuer", "", "URL clients reach the server at, http://<addr> when empty")
+	flag.Parse()
+	if *issuer == "" {
+		*issuer = "http://" + *addr
 	}
 
-	initialRedirectHandler := &GetHandler{
-		Handler:    handlers.HandleInitRedirect,
-		Middleware: LoggingMiddleware{},
+	// the first key is the default used for access tokens, the rest are there for clients that
+	// ask for a different id_token_signed_response_alg
+	keys, err := auth.LoadKeyring("keys/private.pem", "keys/ec_private.pem", "keys/ed25519_private.pem")
+	if err != nil {
+		log.Fatal(err)
 	}
+	pssKey, err := auth.LoadSigningKey("keys/pss_private.pem", "PS256")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = keys.SetActive(pssKey); err != nil {
+		log.Fatal(err)
+	}
+	auth.Keys = keys
 
-	loginEndpointHandler := &GetHandler{
-		Handler:    handlers.HandleLogin,
-		Middleware: LoggingMiddleware{},
+	auth.TokenHashKey, err = auth.LoadTokenHashKey("keys/token_hash.key")
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	tokenEndpointHandler := &PostHandler{
-		Handler:    h.TokenEndpointHandler,
-		Middleware: LoggingMiddleware{},
+	s, err := NewServer(Config{
+		Addr:        *addr,
+		Issuer:      *issuer,
+		ClientsFile: "clients/clients.json",
+		UsersFile:   "clients/users.json",
+		DBPath:      *dbPath,
+	})
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	hJ := JakeHandler{}
-	http.Handle("/authorizationendpoint", authHandler)
-	http.Handle("/tokenendpoint", tokenEndpointHandler)
-	http.Handle("/initRedirect", initialRedirectHandler)
-	http.Handle("/login", loginEndpointHandler)
-	http.Handle("/home", &hJ)
-	log.Println("Started on localhost:8080")
-	log.Fatal(http.ListenAndServe("localhost:8080", nil))
+	// SIGINT/SIGTERM drain in-flight requests before the stores are flushed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err = s.Run(ctx); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Stopped")
 }
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"JakeOAuth/auth"
+	"github.com/stretchr/testify/assert"
+	"net/url"
+	"testing"
+)
+
+func TestRedactBody(t *testing.T) {
+	body := "grant_type=authorization_code&code=abc123&code_verifier=pkce-verifier-value&client_secret=client-secret-value"
+
+	redacted, err := url.ParseQuery(redactBody([]byte(body)))
+
+	assert.Nil(t, err)
+	assert.Equal(t, "authorization_code", redacted.Get("grant_type"))
+	assert.Equal(t, "fingerprint:"+auth.Fingerprint(auth.HashToken("abc123")), redacted.Get("code"))
+	assert.NotContains(t, redacted.Encode(), "pkce-verifier-value")
+	assert.NotContains(t, redacted.Encode(), "client-secret-value")
+}
@@ -0,0 +1,185 @@
+package ratelimit
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Policy is how many requests a key can make and how long it's locked out for after failing
+type Policy struct {
+	// Burst is how many requests can be made at once, it's the size of the bucket
+	Burst int
+	// Interval is how long it takes for one request to be added back to the bucket
+	Interval time.Duration
+	// MaxFailures is how many failures in a row are allowed before the key is locked out
+	MaxFailures int
+	// Lockout is how long the first lockout lasts, every failure after that doubles it up to MaxLockout
+	Lockout    time.Duration
+	MaxLockout time.Duration
+	// ForgetAfter is how long a key has to be left alone before it's forgotten, failures included
+	ForgetAfter time.Duration
+}
+
+// Limiter is a token bucket per key with an exponential lockout after repeated failures. Keys are
+// whatever is being limited, a username, client id or IP address
+type Limiter struct {
+	mu      *sync.Mutex
+	name    string
+	policy  Policy
+	entries map[string]*entry
+	// now is time.Now, tests replace it to move the clock
+	now func() time.Time
+}
+
+type entry struct {
+	tokens      float64
+	refilledAt  time.Time
+	failures    int
+	lockedUntil time.Time
+	lastSeen    time.Time
+	allowed     uint64
+	denied      uint64
+}
+
+// Stat is what the Limiter knows about one key, see Limiter.Stats
+type Stat struct {
+	Key         string     `json:"key"`
+	Tokens      float64    `json:"tokens"`
+	Failures    int        `json:"failures"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+	Allowed     uint64     `json:"allowed"`
+	Denied      uint64     `json:"denied"`
+	LastSeen    time.Time  `json:"last_seen"`
+}
+
+func New(name string, policy Policy) *Limiter {
+	return &Limiter{
+		mu:      &sync.Mutex{},
+		name:    name,
+		policy:  policy,
+		entries: make(map[string]*entry),
+		now:     time.Now,
+	}
+}
+
+func (l *Limiter) Name() string {
+	return l.name
+}
+
+// Allow takes a request out of key's bucket. When the bucket is empty or key is locked out it returns
+// false and how long to wait before trying again
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	e := l.entry(key, now)
+	e.lastSeen = now
+
+	if now.Before(e.lockedUntil) {
+		e.denied++
+		return false, e.lockedUntil.Sub(now)
+	}
+
+	l.refill(e, now)
+	if e.tokens < 1 {
+		e.denied++
+		return false, time.Duration((1 - e.tokens) * float64(l.policy.Interval))
+	}
+	e.tokens--
+	e.allowed++
+	return true, 0
+}
+
+// Fail records a failed attempt for key, e.g. a wrong password. Once there have been MaxFailures in a
+// row the key is locked out, and every failure after that doubles the lockout
+func (l *Limiter) Fail(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	e := l.entry(key, now)
+	e.lastSeen = now
+	e.failures++
+
+	over := e.failures - l.policy.MaxFailures
+	if over < 0 {
+		return
+	}
+	lockout := l.policy.MaxLockout
+	// past 2^30 the shift overflows, the lockout is capped long before that anyway
+	if over < 30 {
+		lockout = min(l.policy.Lockout<<over, l.policy.MaxLockout)
+	}
+	e.lockedUntil = now.Add(lockout)
+	log.Printf("%s rate limit: %s is locked out for %s after %d failures\n", l.name, key, lockout, e.failures)
+}
+
+// Succeed forgets key's failures, it's called after a successful attempt
+func (l *Limiter) Succeed(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if e, ok := l.entries[key]; ok {
+		e.failures = 0
+	}
+}
+
+// Stats returns what the Limiter knows about every key, sorted by key
+func (l *Limiter) Stats() []Stat {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	stats := make([]Stat, 0, len(l.entries))
+	for key, e := range l.entries {
+		l.refill(e, now)
+		s := Stat{
+			Key:      key,
+			Tokens:   e.tokens,
+			Failures: e.failures,
+			Allowed:  e.allowed,
+			Denied:   e.denied,
+			LastSeen: e.lastSeen,
+		}
+		if now.Before(e.lockedUntil) {
+			lockedUntil := e.lockedUntil
+			s.LockedUntil = &lockedUntil
+		}
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Key < stats[j].Key })
+	return stats
+}
+
+// Prune forgets the keys that haven't been seen for ForgetAfter and aren't locked out
+func (l *Limiter) Prune() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	for key, e := range l.entries {
+		if now.Sub(e.lastSeen) >= l.policy.ForgetAfter && !now.Before(e.lockedUntil) {
+			delete(l.entries, key)
+		}
+	}
+}
+
+func (l *Limiter) entry(key string, now time.Time) *entry {
+	e, ok := l.entries[key]
+	if !ok {
+		e = &entry{tokens: float64(l.policy.Burst), refilledAt: now}
+		l.entries[key] = e
+	}
+	return e
+}
+
+func (l *Limiter) refill(e *entry, now time.Time) {
+	if l.policy.Interval > 0 {
+		e.tokens += float64(now.Sub(e.refilledAt)) / float64(l.policy.Interval)
+	}
+	e.tokens = min(e.tokens, float64(l.policy.Burst))
+	e.refilledAt = now
+}
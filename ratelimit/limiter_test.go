@@ -0,0 +1,104 @@
+package ratelimit
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+var testPolicy = Policy{
+	Burst:       3,
+	Interval:    time.Second,
+	MaxFailures: 2,
+	Lockout:     10 * time.Second,
+	MaxLockout:  35 * time.Second,
+	ForgetAfter: 30 * time.Second,
+}
+
+// newTestLimiter returns a Limiter with a clock that only moves when advance is called
+func newTestLimiter() (*Limiter, func(time.Duration)) {
+	l := New("test", testPolicy)
+	now := time.Unix(1_000_000, 0)
+	l.now = func() time.Time { return now }
+	return l, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestLimiter_Allow(t *testing.T) {
+	l, advance := newTestLimiter()
+
+	for i := 0; i < 3; i++ {
+		ok, _ := l.Allow("key")
+		assert.True(t, ok)
+	}
+	ok, retryAfter := l.Allow("key")
+	assert.False(t, ok)
+	assert.Equal(t, time.Second, retryAfter)
+
+	ok, _ = l.Allow("other")
+	assert.True(t, ok, "every key has its own bucket")
+
+	advance(time.Second)
+	ok, _ = l.Allow("key")
+	assert.True(t, ok)
+	ok, _ = l.Allow("key")
+	assert.False(t, ok)
+}
+
+func TestLimiter_Lockout(t *testing.T) {
+	l, advance := newTestLimiter()
+
+	l.Fail("key")
+	ok, _ := l.Allow("key")
+	assert.True(t, ok, "the first failure is allowed")
+
+	var lockouts []time.Duration
+	for i := 0; i < 4; i++ {
+		l.Fail("key")
+		_, retryAfter := l.Allow("key")
+		lockouts = append(lockouts, retryAfter)
+		advance(retryAfter)
+	}
+	assert.Equal(t, []time.Duration{10 * time.Second, 20 * time.Second, 35 * time.Second, 35 * time.Second}, lockouts)
+
+	l.Succeed("key")
+	l.Fail("key")
+	ok, _ = l.Allow("key")
+	assert.True(t, ok, "a success starts counting failures over")
+}
+
+func TestLimiter_Stats(t *testing.T) {
+	l, _ := newTestLimiter()
+	l.Allow("b")
+	l.Fail("a")
+	l.Fail("a")
+	l.Allow("a")
+
+	stats := l.Stats()
+
+	if assert.Len(t, stats, 2) {
+		assert.Equal(t, "a", stats[0].Key)
+		assert.Equal(t, 2, stats[0].Failures)
+		assert.Equal(t, uint64(1), stats[0].Denied)
+		assert.NotNil(t, stats[0].LockedUntil)
+		assert.Equal(t, "b", stats[1].Key)
+		assert.Equal(t, uint64(1), stats[1].Allowed)
+		assert.Equal(t, float64(2), stats[1].Tokens)
+		assert.Nil(t, stats[1].LockedUntil)
+	}
+}
+
+func TestLimiter_Prune(t *testing.T) {
+	l, advance := newTestLimiter()
+	l.Allow("idle")
+	for i := 0; i < 5; i++ {
+		l.Fail("locked")
+	}
+
+	advance(30 * time.Second)
+	l.Prune()
+
+	stats := l.Stats()
+	if assert.Len(t, stats, 1) {
+		assert.Equal(t, "locked", stats[0].Key, "a key isn't forgotten while it's locked out")
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"JakeOAuth/auth"
+	"JakeOAuth/clients"
+	"JakeOAuth/handlers"
+	"JakeOAuth/storage/sqlite"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ReadHeaderTimeout = 5 * time.Second
+	ReadTimeout       = 10 * time.Second
+	WriteTimeout      = 10 * time.Second
+	IdleTimeout       = 60 * time.Second
+	// ShutdownTimeout is how long in-flight requests get to finish once the server is told to stop
+	ShutdownTimeout = 15 * time.Second
+	// PruneInterval is how often expired rows are deleted from the SQLite database
+	PruneInterval = 1 * time.Hour
+	// RateLimitPruneInterval is how often rate limit keys that have been left alone are forgotten
+	RateLimitPruneInterval = 5 * time.Minute
+	// RequestPruneInterval is how often authorization requests nobody finished are deleted
+	RequestPruneInterval = 5 * time.Minute
+	// SessionPruneInterval is how often timed out sessions are deleted from memory, the SQLite
+	// database prunes them every PruneInterval
+	SessionPruneInterval = 5 * time.Minute
+	// DenylistPruneInterval is how often expired access tokens are deleted from the in-memory denylist
+	DenylistPruneInterval = 5 * time.Minute
+	// RefreshTokenPruneInterval is how often expired refresh token families are deleted from memory
+	RefreshTokenPruneInterval = 5 * time.Minute
+)
+
+type Config struct {
+	Addr string
+	// Issuer is the URL clients reach the server at, see auth.Issuer. It can't have a query or fragment
+	// (OpenID Connect Discovery section 3)
+	Issuer      string
+	ClientsFile string
+	UsersFile   string
+	// DBPath is the SQLite database to keep everything in, the stores are in memory when it's empty
+	DBPath string
+}
+
+// Server owns the HTTP server, the stores behind the handlers and the background workers that go with them
+type Server struct {
+	httpServer *http.Server
+	mux        *http.ServeMux
+	handler    *handlers.AuthHandler
+	limits     *handlers.RateLimits
+
+	// codes, tokens, sessions and denylist are only set when they are kept in memory, they need their workers running
+	codes    *auth.AuthorizationCodeStore
+	tokens   *auth.RefreshTokenStore
+	sessions *auth.SessionStore
+	denylist *auth.Denylist
+	// db is only set when the stores are in SQLite
+	db *sql.DB
+}
+
+func NewServer(cfg Config) (*Server, error) {
+	issuer, err := validateIssuer(cfg.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	auth.Issuer = issuer
+
+	registeredClients := clients.ReadClients(cfg.ClientsFile)
+	// auth.Keys has to be loaded first, a client can only get ID Tokens in an alg there is a key for
+	for _, c := range registeredClients {
+		if err = c.CheckIdTokenAlg(auth.Keys.Algorithms()); err != nil {
+			return nil, err
+		}
+	}
+	// plaintext passwords in users.json are hashed when it's opened
+	users, err := clients.OpenUserFile(cfg.UsersFile)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{mux: http.NewServeMux(), limits: handlers.NewRateLimits()}
+	if cfg.DBPath == "" {
+		s.codes = auth.NewAuthCodeStore()
+		s.sessions = auth.NewSessionStore()
+		s.denylist = auth.NewDenylist()
+		auth.RevokedTokens = s.denylist
+		s.tokens = auth.NewRefreshTokenStore()
+		s.handler = handlers.NewAuthHandler(s.codes, s.tokens, registeredClients, users, s.sessions)
+	} else {
+		db, err := sqlite.Open(cfg.DBPath)
+		if err != nil {
+			return nil, err
+		}
+		s.db = db
+
+		// clients.json and users.json only add what isn't in the database yet
+		clientStore := sqlite.NewClientStore(db)
+		if err = clientStore.HashPlaintextSecrets(); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+		if err = clientStore.Import(registeredClients); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+		// clients imported before aren't in clients.json anymore, they need to be checked too
+		if err = clientStore.CheckIdTokenAlgs(auth.Keys.Algorithms()); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+		userStore := sqlite.NewUserStore(db)
+		if err = userStore.HashPlaintextPasswords(); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+		if err = userStore.Import(users.Users()); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+
+		auth.RevokedTokens = sqlite.NewDenylist(db)
+		s.handler = handlers.NewAuthHandler(sqlite.NewCodeStore(db), sqlite.NewTokenStore(db), clientStore, userStore, sqlite.NewSessionStore(db))
+	}
+
+	s.routes()
+	s.httpServer = &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           s.mux,
+		ReadHeaderTimeout: ReadHeaderTimeout,
+		ReadTimeout:       ReadTimeout,
+		WriteTimeout:      WriteTimeout,
+		IdleTimeout:       IdleTimeout,
+	}
+	return s, nil
+}
+
+// validateIssuer checks issuer is an http(s) URL without a query or fragment and drops a trailing slash,
+// the endpoint paths are added straight onto it
+func validateIssuer(issuer string) (string, error) {
+	u, err := url.Parse(issuer)
+	if err != nil {
+		return "", fmt.Errorf("invalid issuer %q: %w", issuer, err)
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("invalid issuer %q: it has to be an http or https URL without a query or fragment", issuer)
+	}
+	return strings.TrimSuffix(issuer, "/"), nil
+}
+
+func (s *Server) routes() {
+	h := s.handler
+
+	authHandler := &GetHandler{
+		Handler:    h.AuthorizationEndpointHandler,
+		Middleware: LoggingMiddleware{},
+	}
+
+	loginHandler := &PageHandler{
+		Handler:    s.limits.Login(h.HandleLogin),
+		Middleware: LoggingMiddleware{},
+	}
+
+	consentHandler := &PageHandler{
+		Handler:    h.HandleConsent,
+		Middleware: LoggingMiddleware{},
+	}
+
+	tokenEndpointHandler := &PostHandler{
+		Handler:    s.limits.ClientAuth(h.TokenEndpointHandler),
+		Middleware: LoggingMiddleware{},
+	}
+
+	introspectionHandler := &PostHandler{
+		Handler:    s.limits.ClientAuth(h.IntrospectionHandler),
+		Middleware: LoggingMiddleware{},
+	}
+
+	revocationHandler := &PostHandler{
+		Handler:    s.limits.ClientAuth(h.RevocationHandler),
+		Middleware: LoggingMiddleware{},
+	}
+
+	discoveryHandler := &GetHandler{
+		Handler:    handlers.DiscoveryHandler,
+		Middleware: LoggingMiddleware{},
+	}
+
+	userInfoHandler := &GetHandler{
+		Handler:    handlers.UserInfoHandler,
+		Middleware: LoggingMiddleware{},
+	}
+
+	jwksHandler := &GetHandler{
+		Handler:    handlers.JwksHandler,
+		Middleware: LoggingMiddleware{},
+	}
+
+	adminRateLimitsHandler := &GetHandler{
+		Handler:    s.limits.AdminRateLimitsHandler,
+		Middleware: LoggingMiddleware{},
+	}
+
+	hJ := JakeHandler{}
+	s.mux.Handle(handlers.AuthorizationEndpointPath, authHandler)
+	s.mux.Handle(handlers.TokenEndpointPath, tokenEndpointHandler)
+	s.mux.Handle(handlers.IntrospectionEndpointPath, introspectionHandler)
+	s.mux.Handle(handlers.RevocationEndpointPath, revocationHandler)
+	s.mux.Handle(handlers.DiscoveryPath, discoveryHandler)
+	s.mux.Handle(handlers.UserInfoEndpointPath, userInfoHandler)
+	s.mux.Handle(handlers.JwksPath, jwksHandler)
+	s.mux.Handle(handlers.AdminRateLimitsPath, adminRateLimitsHandler)
+	s.mux.Handle(handlers.LoginPath, loginHandler)
+	s.mux.Handle(handlers.ConsentPath, consentHandler)
+	s.mux.Handle("/home", &hJ)
+}
+
+// Run listens on the configured address and serves until ctx is done, see Serve
+func (s *Server) Run(ctx context.Context) error {
+	l, err := net.Listen("tcp", s.httpServer.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, l)
+}
+
+// Serve starts the background workers and serves requests on l until ctx is done. Then it stops accepting
+// connections, waits up to ShutdownTimeout for in-flight requests, stops the workers and flushes the stores
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	// the workers get their own context, they have to keep running while requests are drained
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	workers := &sync.WaitGroup{}
+	s.startWorkers(workerCtx, workers)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.httpServer.Serve(l)
+	}()
+	log.Printf("Started on %s\n", l.Addr())
+
+	var err error
+	select {
+	case err = <-serveErr:
+	case <-ctx.Done():
+		log.Println("Shutting down, draining in-flight requests")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+		err = s.httpServer.Shutdown(shutdownCtx)
+		cancel()
+		<-serveErr
+	}
+
+	stopWorkers()
+	workers.Wait()
+
+	if closeErr := s.close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (s *Server) startWorkers(ctx context.Context, workers *sync.WaitGroup) {
+	start := func(worker func()) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			worker()
+		}()
+	}
+
+	start(func() { auth.Keys.StartRotation(ctx, auth.KeyRotationInterval) })
+	if s.codes != nil {
+		start(func() { s.codes.StartExpiration(ctx) })
+		// returns once StartExpiration closes the channel
+		start(s.codes.ListenExpiration)
+	}
+	if s.tokens != nil {
+		start(func() { s.tokens.StartPruning(ctx, RefreshTokenPruneInterval) })
+	}
+	if s.sessions != nil {
+		start(func() { s.sessions.StartPruning(ctx, SessionPruneInterval) })
+	}
+	if s.denylist != nil {
+		start(func() { s.denylist.StartPruning(ctx, DenylistPruneInterval) })
+	}
+	if s.db != nil {
+		start(func() { sqlite.StartPruning(ctx, s.db, PruneInterval) })
+	}
+	start(func() { s.limits.StartPruning(ctx, RateLimitPruneInterval) })
+	start(func() { s.handler.Requests.StartPruning(ctx, RequestPruneInterval) })
+}
+
+// close flushes and closes the database, the in-memory stores have nothing to flush
+func (s *Server) close() error {
+	if s.db == nil {
+		return nil
+	}
+	if err := sqlite.Flush(s.db); err != nil {
+		log.Println("error: failed to flush database:", err)
+	}
+	return s.db.Close()
+}
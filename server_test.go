@@ -0,0 +1,147 @@
+package main
+
+import (
+	"JakeOAuth/auth"
+	"JakeOAuth/handlers"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func init() {
+	var err error
+	auth.Keys, err = auth.LoadKeyring("keys/private.pem")
+	if err != nil {
+		panic(err)
+	}
+}
+
+func testConfig() Config {
+	return Config{
+		Issuer:      "http://localhost:8080",
+		ClientsFile: "clients/clients.json",
+		UsersFile:   "clients/users.json",
+	}
+}
+
+// serve starts s on a random port, the returned channel gets what Serve returned
+func serve(t *testing.T, s *Server, ctx context.Context) (string, chan error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Serve(ctx, l)
+	}()
+	return "http://" + l.Addr().String(), done
+}
+
+func TestServer_ShutdownDrainsRequests(t *testing.T) {
+	s, err := NewServer(testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mux.HandleFunc("/slow", func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		_, _ = w.Write([]byte("done"))
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	url, done := serve(t, s, ctx)
+
+	type result struct {
+		body string
+		err  error
+	}
+	slow := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(url + "/slow")
+		if err != nil {
+			slow <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		slow <- result{body: string(body), err: err}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	r := <-slow
+	assert.Nil(t, r.err)
+	assert.Equal(t, "done", r.body)
+	select {
+	case err = <-done:
+		assert.Nil(t, err)
+	case <-time.After(ShutdownTimeout):
+		t.Fatal("Serve did not return after shutdown")
+	}
+
+	_, err = http.Get(url + handlers.DiscoveryPath)
+	assert.Error(t, err)
+}
+
+func TestServer_SQLite(t *testing.T) {
+	cfg := testConfig()
+	cfg.DBPath = filepath.Join(t.TempDir(), "jakeoauth.db")
+	s, err := NewServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	url, done := serve(t, s, ctx)
+
+	resp, err := http.Get(url + handlers.DiscoveryPath)
+	if assert.Nil(t, err) {
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		_ = resp.Body.Close()
+	}
+	cancel()
+	assert.Nil(t, <-done)
+
+	// the database was closed on shutdown
+	assert.Error(t, s.db.Ping())
+	auth.RevokedTokens = auth.NewDenylist()
+}
+
+func TestNewServer_Issuer(t *testing.T) {
+	cfg := testConfig()
+	cfg.Issuer = "https://id.example.com/"
+
+	_, err := NewServer(cfg)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "https://id.example.com", auth.Issuer, "the trailing slash is dropped")
+
+	for _, issuer := range []string{"", "id.example.com", "ftp://id.example.com", "https://id.example.com?tenant=a", "https://id.example.com#a"} {
+		cfg.Issuer = issuer
+		_, err = NewServer(cfg)
+		assert.Errorf(t, err, "issuer %q was accepted", issuer)
+	}
+	auth.Issuer = testConfig().Issuer
+}
+
+func TestNewServer_IdTokenAlg(t *testing.T) {
+	clientsFile := filepath.Join(t.TempDir(), "clients.json")
+	err := os.WriteFile(clientsFile, []byte(`{"es": {"name": "es", "type": "authorization_code", "client_id": "es",
+		"redirect_uris": ["https://example.com/callback"], "id_token_signed_response_alg": "ES256"}}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := testConfig()
+	cfg.ClientsFile = clientsFile
+
+	// keys/private.pem only has an RS256 key
+	_, err = NewServer(cfg)
+	assert.ErrorContains(t, err, "ES256")
+
+	cfg.DBPath = filepath.Join(t.TempDir(), "jakeoauth.db")
+	_, err = NewServer(cfg)
+	assert.ErrorContains(t, err, "ES256")
+}
@@ -0,0 +1,284 @@
+package sqlite
+
+import (
+	"JakeOAuth/clients"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+)
+
+// ClientStore is a storage.ClientStore kept in the clients table
+type ClientStore struct {
+	db *sql.DB
+}
+
+func NewClientStore(db *sql.DB) *ClientStore {
+	return &ClientStore{db: db}
+}
+
+func (cs *ClientStore) Client(clientId string) (clients.Client, error) {
+	var c clients.Client
+	var redirectUris, allowedScopes, allowedResources string
+	err := cs.db.QueryRow(`SELECT client_id, name, type, description, redirect_uris, allowed_scopes, allowed_resources,
+		resource_server, id_token_signed_response_alg FROM clients WHERE client_id = ?`, clientId).
+		Scan(&c.ClientId, &c.Name, &c.Type, &c.Description, &redirectUris, &allowedScopes, &allowedResources,
+			&c.ResourceServer, &c.IdTokenSignedResponseAlg)
+	if errors.Is(err, sql.ErrNoRows) {
+		return clients.Client{}, clients.ErrClientNotFound
+	}
+	if err != nil {
+		return clients.Client{}, err
+	}
+
+	if err = json.Unmarshal([]byte(redirectUris), &c.RedirectUris); err != nil {
+		return clients.Client{}, err
+	}
+	if err = json.Unmarshal([]byte(allowedScopes), &c.AllowedScopes); err != nil {
+		return clients.Client{}, err
+	}
+	if err = json.Unmarshal([]byte(allowedResources), &c.AllowedResources); err != nil {
+		return clients.Client{}, err
+	}
+	if c.ClientSecrets, err = cs.secrets(clientId); err != nil {
+		return clients.Client{}, err
+	}
+	return c, nil
+}
+
+func (cs *ClientStore) secrets(clientId string) ([]clients.ClientSecret, error) {
+	rows, err := cs.db.Query(`SELECT secret_hash, expires_at FROM client_secrets WHERE client_id = ?`, clientId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var secrets []clients.ClientSecret
+	for rows.Next() {
+		var s clients.ClientSecret
+		if err = rows.Scan(&s.Hash, &s.ExpiresAt); err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, s)
+	}
+	return secrets, rows.Err()
+}
+
+// AddSecret adds a secret to the client, or changes when it expires if the client already has it
+func (cs *ClientStore) AddSecret(clientId string, secret clients.ClientSecret) error {
+	_, err := cs.db.Exec(`INSERT INTO client_secrets (client_id, secret_hash, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (client_id, secret_hash) DO UPDATE SET expires_at = excluded.expires_at`,
+		clientId, secret.Hash, secret.ExpiresAt)
+	return err
+}
+
+// Import adds every client in c that isn't already in the database, so clients.json can seed it.
+// Clients are stored under their key in c, the same id clients.Clients looks them up by. The secrets
+// of a client in c are synced with it, so a secret rotated in or removed from clients.json is picked
+// up on the next start
+func (cs *ClientStore) Import(c clients.Clients) error {
+	for clientId, client := range c {
+		redirectUris, err := json.Marshal(client.RedirectUris)
+		if err != nil {
+			return err
+		}
+		allowedScopes, err := json.Marshal(client.AllowedScopes)
+		if err != nil {
+			return err
+		}
+		allowedResources, err := json.Marshal(client.AllowedResources)
+		if err != nil {
+			return err
+		}
+
+		_, err = cs.db.Exec(`INSERT OR IGNORE INTO clients (client_id, name, type, description, plaintext_secret, redirect_uris,
+			allowed_scopes, allowed_resources, resource_server, id_token_signed_response_alg) VALUES (?, ?, ?, ?, '', ?, ?, ?, ?, ?)`,
+			clientId, client.Name, client.Type, client.Description, string(redirectUris),
+			string(allowedScopes), string(allowedResources), client.ResourceServer, client.IdTokenSignedResponseAlg)
+		if err != nil {
+			return err
+		}
+		for _, secret := range client.ClientSecrets {
+			if err = cs.AddSecret(clientId, secret); err != nil {
+				return err
+			}
+		}
+		if err = cs.removeSecrets(clientId, client.ClientSecrets); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeSecrets deletes the client's secrets that aren't in keep
+func (cs *ClientStore) removeSecrets(clientId string, keep []clients.ClientSecret) error {
+	stored, err := cs.secrets(clientId)
+	if err != nil {
+		return err
+	}
+
+	kept := make(map[string]bool, len(keep))
+	for _, secret := range keep {
+		kept[secret.Hash] = true
+	}
+	removed := 0
+	for _, secret := range stored {
+		if kept[secret.Hash] {
+			continue
+		}
+		if _, err = cs.db.Exec(`DELETE FROM client_secrets WHERE client_id = ? AND secret_hash = ?`, clientId, secret.Hash); err != nil {
+			return err
+		}
+		removed++
+	}
+	if removed > 0 {
+		log.Printf("deleted %d secrets of client %s that are no longer in the clients file\n", removed, clientId)
+	}
+	return nil
+}
+
+// CheckIdTokenAlgs checks the ID Token alg of every client in the database, see clients.Client.CheckIdTokenAlg
+func (cs *ClientStore) CheckIdTokenAlgs(algs []string) error {
+	rows, err := cs.db.Query(`SELECT client_id, id_token_signed_response_alg FROM clients`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c clients.Client
+		if err = rows.Scan(&c.ClientId, &c.IdTokenSignedResponseAlg); err != nil {
+			return err
+		}
+		if err = c.CheckIdTokenAlg(algs); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// HashPlaintextSecrets moves the secrets that were stored in plaintext before migration 0004 into client_secrets
+func (cs *ClientStore) HashPlaintextSecrets() error {
+	rows, err := cs.db.Query(`SELECT client_id, plaintext_secret FROM clients WHERE plaintext_secret != ''`)
+	if err != nil {
+		return err
+	}
+	plaintext := make(map[string]string)
+	for rows.Next() {
+		var clientId, secret string
+		if err = rows.Scan(&clientId, &secret); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		plaintext[clientId] = secret
+	}
+	err = rows.Err()
+	// rows has to be closed before updating, there is only one connection
+	_ = rows.Close()
+	if err != nil {
+		return err
+	}
+
+	for clientId, secret := range plaintext {
+		if err = cs.AddSecret(clientId, clients.ClientSecret{Hash: clients.HashClientSecret(secret)}); err != nil {
+			return err
+		}
+		if _, err = cs.db.Exec(`UPDATE clients SET plaintext_secret = '' WHERE client_id = ?`, clientId); err != nil {
+			return err
+		}
+	}
+	if len(plaintext) > 0 {
+		log.Printf("hashed %d plaintext client secrets in the database\n", len(plaintext))
+	}
+	return nil
+}
+
+// UserStore is a storage.UserStore kept in the users table
+type UserStore struct {
+	db *sql.DB
+}
+
+func NewUserStore(db *sql.DB) *UserStore {
+	return &UserStore{db: db}
+}
+
+func (us *UserStore) User(username string) (clients.User, error) {
+	var u clients.User
+	err := us.db.QueryRow(`SELECT password_hash FROM users WHERE username = ?`, username).Scan(&u.PasswordHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return clients.User{}, clients.ErrUserNotFound
+	}
+	if err != nil {
+		return clients.User{}, err
+	}
+	return u, nil
+}
+
+func (us *UserStore) SetPasswordHash(username, hash string) error {
+	result, err := us.db.Exec(`UPDATE users SET password_hash = ? WHERE username = ?`, hash, username)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return clients.ErrUserNotFound
+	}
+	return nil
+}
+
+// Import adds every user in u that isn't already in the database, so users.json can seed it
+func (us *UserStore) Import(u clients.Users) error {
+	for username, user := range u {
+		hash := user.PasswordHash
+		if hash == "" {
+			var err error
+			if hash, err = clients.HashPassword(user.Password); err != nil {
+				return err
+			}
+		}
+
+		_, err := us.db.Exec(`INSERT OR IGNORE INTO users (username, password_hash) VALUES (?, ?)`, username, hash)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HashPlaintextPasswords hashes the passwords that were stored in plaintext before migration 0003
+func (us *UserStore) HashPlaintextPasswords() error {
+	rows, err := us.db.Query(`SELECT username, password_hash FROM users`)
+	if err != nil {
+		return err
+	}
+	plaintext := make(map[string]string)
+	for rows.Next() {
+		var username, password string
+		if err = rows.Scan(&username, &password); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		if !clients.IsPasswordHash(password) {
+			plaintext[username] = password
+		}
+	}
+	err = rows.Err()
+	// rows has to be closed before updating, there is only one connection
+	_ = rows.Close()
+	if err != nil {
+		return err
+	}
+
+	for username, password := range plaintext {
+		hash, err := clients.HashPassword(password)
+		if err != nil {
+			return err
+		}
+		if err = us.SetPasswordHash(username, hash); err != nil {
+			return err
+		}
+	}
+	if len(plaintext) > 0 {
+		log.Printf("hashed %d plaintext passwords in the database\n", len(plaintext))
+	}
+	return nil
+}
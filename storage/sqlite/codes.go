@@ -0,0 +1,66 @@
+package sqlite
+
+import (
+	"JakeOAuth/auth"
+	"database/sql"
+	"errors"
+)
+
+// CodeStore is a storage.CodeStore kept in the authorization_codes table, codes are stored by their hash
+type CodeStore struct {
+	db *sql.DB
+}
+
+func NewCodeStore(db *sql.DB) *CodeStore {
+	return &CodeStore{db: db}
+}
+
+func (cs *CodeStore) Add(code *auth.AuthorizationCode) error {
+	_, err := cs.db.Exec(`INSERT INTO authorization_codes
+		(code_hash, exp, pkce, hash_method, state, client_id, redirect_uri, scope, subject, requested_scope, nonce, auth_time, grant_id, redeemed)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		auth.HashToken(code.Code), code.Exp, code.Pkce, code.HashMethod, code.State, code.ClientId, code.RedirectUri, code.Scope,
+		code.Subject, code.RequestedScope, code.Nonce, code.AuthTime, code.GrantId, code.Redeemed)
+	return err
+}
+
+// Redeem works like auth.AuthorizationCodeStore.Redeem, the lookup and marking the code as
+// redeemed happen in one transaction so a code can't be redeemed twice
+func (cs *CodeStore) Redeem(authCode, pkceCode, clientId, redirectUri string) (*auth.AuthorizationCode, error) {
+	tx, err := cs.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	codeHash := auth.HashToken(authCode)
+	code := &auth.AuthorizationCode{}
+	err = tx.QueryRow(`SELECT code_hash, exp, pkce, hash_method, state, client_id, redirect_uri, scope, subject,
+		requested_scope, nonce, auth_time, grant_id, redeemed FROM authorization_codes WHERE code_hash = ?`, codeHash).
+		Scan(&code.CodeHash, &code.Exp, &code.Pkce, &code.HashMethod, &code.State, &code.ClientId, &code.RedirectUri, &code.Scope,
+			&code.Subject, &code.RequestedScope, &code.Nonce, &code.AuthTime, &code.GrantId, &code.Redeemed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, auth.ErrCodeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if code.Redeemed {
+		return code, auth.ErrCodeReplayed
+	}
+
+	if err = code.Verify(pkceCode, clientId, redirectUri); err != nil {
+		return nil, err
+	}
+
+	if _, err = tx.Exec(`UPDATE authorization_codes SET redeemed = 1 WHERE code_hash = ?`, codeHash); err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	code.Redeemed = true
+	return code, nil
+}
@@ -0,0 +1,59 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// Denylist is an auth.TokenDenylist kept in the issued_access_tokens and revoked_access_tokens tables
+type Denylist struct {
+	db *sql.DB
+}
+
+func NewDenylist(db *sql.DB) *Denylist {
+	return &Denylist{db: db}
+}
+
+func (d *Denylist) Track(grantId, jti string, exp int64) error {
+	_, err := d.db.Exec(`INSERT INTO issued_access_tokens (jti, grant_id, exp) VALUES (?, ?, ?)`, jti, grantId, exp)
+	return err
+}
+
+func (d *Denylist) Revoke(jti string, exp int64) error {
+	_, err := d.db.Exec(`INSERT OR REPLACE INTO revoked_access_tokens (jti, exp) VALUES (?, ?)`, jti, exp)
+	return err
+}
+
+func (d *Denylist) RevokeGrant(grantId string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.Exec(`INSERT OR REPLACE INTO revoked_access_tokens (jti, exp)
+		SELECT jti, exp FROM issued_access_tokens WHERE grant_id = ?`, grantId)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM issued_access_tokens WHERE grant_id = ?`, grantId); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// IsRevoked says the token is revoked if the database can't be read, letting a revoked token through is worse
+func (d *Denylist) IsRevoked(jti string) bool {
+	var exp int64
+	err := d.db.QueryRow(`SELECT exp FROM revoked_access_tokens WHERE jti = ?`, jti).Scan(&exp)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false
+	}
+	if err != nil {
+		log.Println("error: failed to check access token denylist:", err)
+		return true
+	}
+	return exp >= time.Now().Unix()
+}
@@ -0,0 +1,60 @@
+CREATE TABLE authorization_codes (
+    code            TEXT PRIMARY KEY,
+    exp             INTEGER NOT NULL,
+    pkce            TEXT    NOT NULL,
+    hash_method     TEXT    NOT NULL,
+    state           TEXT    NOT NULL,
+    client_id       TEXT    NOT NULL,
+    redirect_uri    TEXT    NOT NULL,
+    scope           TEXT    NOT NULL,
+    subject         TEXT    NOT NULL,
+    requested_scope TEXT    NOT NULL,
+    nonce           TEXT    NOT NULL,
+    auth_time       INTEGER NOT NULL,
+    grant_id        TEXT    NOT NULL,
+    redeemed        INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX authorization_codes_exp ON authorization_codes (exp);
+
+CREATE TABLE refresh_tokens (
+    token     TEXT PRIMARY KEY,
+    id        TEXT    NOT NULL UNIQUE,
+    exp       INTEGER NOT NULL,
+    issued_at INTEGER NOT NULL,
+    client_id TEXT    NOT NULL,
+    subject   TEXT    NOT NULL,
+    scope     TEXT    NOT NULL,
+    family_id TEXT    NOT NULL,
+    used      INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX refresh_tokens_family_id ON refresh_tokens (family_id);
+
+-- access tokens issued for a grant, so they can all be revoked with it
+CREATE TABLE issued_access_tokens (
+    jti      TEXT PRIMARY KEY,
+    grant_id TEXT    NOT NULL,
+    exp      INTEGER NOT NULL
+);
+CREATE INDEX issued_access_tokens_grant_id ON issued_access_tokens (grant_id);
+
+CREATE TABLE revoked_access_tokens (
+    jti TEXT PRIMARY KEY,
+    exp INTEGER NOT NULL
+);
+
+-- redirect_uris and allowed_scopes are JSON arrays
+CREATE TABLE clients (
+    client_id                    TEXT PRIMARY KEY,
+    name                         TEXT NOT NULL,
+    type                         TEXT NOT NULL,
+    description                  TEXT NOT NULL,
+    client_secret                TEXT NOT NULL,
+    redirect_uris                TEXT NOT NULL,
+    allowed_scopes               TEXT NOT NULL,
+    id_token_signed_response_alg TEXT NOT NULL
+);
+
+CREATE TABLE users (
+    username TEXT PRIMARY KEY,
+    password TEXT NOT NULL
+);
@@ -0,0 +1,7 @@
+-- codes and refresh tokens are only stored as HMAC-SHA256 hashes from now on. The raw values
+-- stored before can't be hashed without the server secret, so they are dropped and clients have
+-- to go through the authorization code flow again
+DELETE FROM authorization_codes;
+DELETE FROM refresh_tokens;
+ALTER TABLE authorization_codes RENAME COLUMN code TO code_hash;
+ALTER TABLE refresh_tokens RENAME COLUMN token TO token_hash;
@@ -0,0 +1,3 @@
+-- passwords are PHC strings from now on, UserStore.HashPlaintextPasswords hashes the plaintext
+-- passwords stored before
+ALTER TABLE users RENAME COLUMN password TO password_hash;
@@ -0,0 +1,10 @@
+-- a client can have several secrets, each stored as its SHA-256. expires_at is 0 for a secret that doesn't expire
+CREATE TABLE client_secrets (
+    client_id   TEXT    NOT NULL REFERENCES clients (client_id),
+    secret_hash TEXT    NOT NULL,
+    expires_at  INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (client_id, secret_hash)
+);
+
+-- ClientStore.HashPlaintextSecrets moves the secrets stored before into client_secrets
+ALTER TABLE clients RENAME COLUMN client_secret TO plaintext_secret;
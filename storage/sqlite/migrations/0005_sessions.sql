@@ -0,0 +1,9 @@
+-- sessions are stored by the hash of their id, like codes and refresh tokens
+CREATE TABLE sessions (
+    id_hash   TEXT PRIMARY KEY,
+    subject   TEXT    NOT NULL,
+    auth_time INTEGER NOT NULL,
+    exp       INTEGER NOT NULL,
+    idle_exp  INTEGER NOT NULL
+);
+CREATE INDEX sessions_exp ON sessions (exp);
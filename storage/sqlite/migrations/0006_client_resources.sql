@@ -0,0 +1,2 @@
+-- allowed_resources is a JSON array like allowed_scopes, clients from before it can only get tokens for the issuer
+ALTER TABLE clients ADD COLUMN allowed_resources TEXT NOT NULL DEFAULT '[]';
@@ -0,0 +1,2 @@
+-- resource servers can introspect tokens issued to any client, other clients only their own
+ALTER TABLE clients ADD COLUMN resource_server INTEGER NOT NULL DEFAULT 0;
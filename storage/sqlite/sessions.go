@@ -0,0 +1,62 @@
+package sqlite
+
+import (
+	"JakeOAuth/auth"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// SessionStore is a storage.SessionStore kept in the sessions table, sessions are stored by the hash of their id
+type SessionStore struct {
+	db *sql.DB
+}
+
+func NewSessionStore(db *sql.DB) *SessionStore {
+	return &SessionStore{db: db}
+}
+
+func (ss *SessionStore) Create(subject string) (*auth.Session, error) {
+	s, err := auth.NewSession(subject)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = ss.db.Exec(`INSERT INTO sessions (id_hash, subject, auth_time, exp, idle_exp) VALUES (?, ?, ?, ?, ?)`,
+		s.IdHash, s.Subject, s.AuthTime, s.Exp, s.IdleExp)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (ss *SessionStore) Get(id string) (auth.Session, error) {
+	var s auth.Session
+	err := ss.db.QueryRow(`SELECT id_hash, subject, auth_time, exp, idle_exp FROM sessions WHERE id_hash = ?`, auth.HashToken(id)).
+		Scan(&s.IdHash, &s.Subject, &s.AuthTime, &s.Exp, &s.IdleExp)
+	if errors.Is(err, sql.ErrNoRows) {
+		return auth.Session{}, auth.ErrSessionNotFound
+	}
+	if err != nil {
+		return auth.Session{}, err
+	}
+
+	now := time.Now()
+	if !s.Active(now) {
+		if _, err = ss.db.Exec(`DELETE FROM sessions WHERE id_hash = ?`, s.IdHash); err != nil {
+			return auth.Session{}, err
+		}
+		return auth.Session{}, auth.ErrSessionNotFound
+	}
+
+	s.IdleExp = now.Add(auth.SessionIdleTimeout).Unix()
+	if _, err = ss.db.Exec(`UPDATE sessions SET idle_exp = ? WHERE id_hash = ?`, s.IdleExp, s.IdHash); err != nil {
+		return auth.Session{}, err
+	}
+	return s, nil
+}
+
+func (ss *SessionStore) Delete(id string) error {
+	_, err := ss.db.Exec(`DELETE FROM sessions WHERE id_hash = ?`, auth.HashToken(id))
+	return err
+}
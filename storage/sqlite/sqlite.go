@@ -0,0 +1,158 @@
+// Package sqlite is the SQLite backend for the storage interfaces. Everything is kept in a single
+// database file that is migrated to the latest schema when it's opened
+package sqlite
+
+import (
+	"JakeOAuth/auth"
+	"JakeOAuth/storage"
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// Open opens the database at path, creating it if it doesn't exist, and applies any migrations it is missing
+func Open(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite only has one writer at a time, with a single connection transactions wait
+	// for each other instead of failing with SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+
+	if err = Migrate(db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Migrate applies every migration in migrations/ that hasn't been applied yet, in order of the
+// version number the file name starts with. Each migration runs in its own transaction
+func Migrate(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	var current int
+	err = db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	names, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		version, err := migrationVersion(name)
+		if err != nil {
+			return err
+		}
+		if version <= current {
+			continue
+		}
+
+		script, err := migrations.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if err = applyMigration(db, version, string(script)); err != nil {
+			return fmt.Errorf("migration %s failed: %w", name, err)
+		}
+		log.Printf("applied database migration %s\n", name)
+	}
+	return nil
+}
+
+// migrationVersion is the number before the first _ in migrations/0001_init.sql
+func migrationVersion(name string) (int, error) {
+	base := strings.TrimPrefix(name, "migrations/")
+	prefix, _, found := strings.Cut(base, "_")
+	if !found {
+		return 0, fmt.Errorf("migration %s is not named <version>_<name>.sql", name)
+	}
+	return strconv.Atoi(prefix)
+}
+
+func applyMigration(db *sql.DB, version int, script string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err = tx.Exec(script); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`, version, time.Now().Unix()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Prune deletes expired codes, refresh tokens, denylist entries and sessions. Expired rows are never
+// valid anyway, this only keeps the database from growing forever
+func Prune(db *sql.DB) error {
+	now := time.Now().Unix()
+	for _, table := range []string{"authorization_codes", "refresh_tokens", "issued_access_tokens", "revoked_access_tokens", "sessions"} {
+		if _, err := db.Exec(`DELETE FROM `+table+` WHERE exp < ?`, now); err != nil {
+			return fmt.Errorf("failed to prune %s: %w", table, err)
+		}
+	}
+	// sessions also end when they haven't been used for a while
+	if _, err := db.Exec(`DELETE FROM sessions WHERE idle_exp <= ?`, now); err != nil {
+		return fmt.Errorf("failed to prune sessions: %w", err)
+	}
+	return nil
+}
+
+// Flush checkpoints the write-ahead log into the database file, it's called before the database is closed
+func Flush(db *sql.DB) error {
+	_, err := db.Exec(`PRAGMA wal_checkpoint(TRUNCATE)`)
+	return err
+}
+
+// StartPruning calls Prune every interval until ctx is done
+func StartPruning(ctx context.Context, db *sql.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := Prune(db); err != nil {
+				log.Println("error: failed to prune database:", err)
+			}
+		}
+	}
+}
+
+var (
+	_ storage.CodeStore    = (*CodeStore)(nil)
+	_ storage.TokenStore   = (*TokenStore)(nil)
+	_ storage.ClientStore  = (*ClientStore)(nil)
+	_ storage.UserStore    = (*UserStore)(nil)
+	_ storage.SessionStore = (*SessionStore)(nil)
+	_ auth.TokenDenylist   = (*Denylist)(nil)
+)
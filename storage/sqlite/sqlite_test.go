@@ -0,0 +1,308 @@
+package sqlite
+
+import (
+	"JakeOAuth/auth"
+	"JakeOAuth/clients"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestDB(t *testing.T) (*sql.DB, string) {
+	path := filepath.Join(t.TempDir(), "jakeoauth.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db, path
+}
+
+func TestOpen_MigratesOnce(t *testing.T) {
+	db, path := openTestDB(t)
+	_ = db.Close()
+
+	db, err := Open(path)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	var count int
+	assert.Nil(t, db.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&count))
+	assert.Equal(t, 7, count)
+}
+
+func TestCodeStore_Redeem(t *testing.T) {
+	db, path := openTestDB(t)
+	verifier := "verifier"
+	hashed := sha256.Sum256([]byte(verifier))
+	code := auth.NewAuthorizationCode(base64.RawURLEncoding.EncodeToString(hashed[:]), "S256", "state")
+	code.ClientId = "client"
+	code.RedirectUri = "https://example.com/callback"
+	code.Scope = "openid"
+	assert.Nil(t, NewCodeStore(db).Add(code))
+
+	// the code survives a restart
+	_ = db.Close()
+	db, err := Open(path)
+	assert.Nil(t, err)
+	defer db.Close()
+	cs := NewCodeStore(db)
+
+	redeemed, err := cs.Redeem(code.Code, verifier, "client", "https://example.com/callback")
+	if assert.Nil(t, err) {
+		assert.Equal(t, code.GrantId, redeemed.GrantId)
+		assert.Equal(t, "openid", redeemed.Scope)
+	}
+
+	replayed, err := cs.Redeem(code.Code, verifier, "client", "https://example.com/callback")
+	assert.ErrorIs(t, err, auth.ErrCodeReplayed)
+	assert.Equal(t, code.GrantId, replayed.GrantId)
+}
+
+func TestCodeStore_Redeem_WrongClient(t *testing.T) {
+	db, _ := openTestDB(t)
+	cs := NewCodeStore(db)
+	code := auth.NewAuthorizationCode("verifier", "plain", "")
+	code.ClientId = "client"
+	_ = cs.Add(code)
+
+	_, err := cs.Redeem(code.Code, "verifier", "other-client", "")
+	assert.Error(t, err)
+
+	// a failed attempt doesn't use up the code
+	_, err = cs.Redeem(code.Code, "verifier", "client", "")
+	assert.Nil(t, err)
+}
+
+func TestCodeStore_Redeem_NotFound(t *testing.T) {
+	db, _ := openTestDB(t)
+
+	_, err := NewCodeStore(db).Redeem("nope", "", "", "")
+
+	assert.ErrorIs(t, err, auth.ErrCodeNotFound)
+}
+
+func TestTokenStore_Rotate(t *testing.T) {
+	db, _ := openTestDB(t)
+	ts := NewTokenStore(db)
+	rt, err := ts.Issue("", "client", "jakedanson", "openid")
+	assert.Nil(t, err)
+
+	rotated, err := ts.Rotate(rt.Token, "client")
+
+	if assert.Nil(t, err) {
+		assert.NotEqual(t, rt.Token, rotated.Token)
+		assert.Equal(t, rt.FamilyId, rotated.FamilyId)
+		assert.Equal(t, "jakedanson", rotated.Subject)
+	}
+	peeked, err := ts.Peek(rt.Token)
+	assert.Nil(t, err)
+	assert.True(t, peeked.Used)
+}
+
+func TestTokenStore_Rotate_ReuseRevokesFamily(t *testing.T) {
+	db, _ := openTestDB(t)
+	ts := NewTokenStore(db)
+	rt, _ := ts.Issue("", "client", "", "")
+	rotated, _ := ts.Rotate(rt.Token, "client")
+
+	_, err := ts.Rotate(rt.Token, "client")
+	if assert.Error(t, err) {
+		assert.Equal(t, "refresh token has already been used", err.Error())
+	}
+
+	_, err = ts.Peek(rotated.Token)
+	assert.ErrorIs(t, err, auth.ErrRefreshTokenNotFound)
+}
+
+func TestDenylist_RevokeGrant(t *testing.T) {
+	db, _ := openTestDB(t)
+	d := NewDenylist(db)
+	exp := time.Now().Add(time.Minute).Unix()
+	assert.Nil(t, d.Track("grant", "first", exp))
+	assert.Nil(t, d.Track("other-grant", "second", exp))
+
+	assert.Nil(t, d.RevokeGrant("grant"))
+
+	assert.True(t, d.IsRevoked("first"))
+	assert.False(t, d.IsRevoked("second"))
+}
+
+func TestClientStore_Import(t *testing.T) {
+	db, _ := openTestDB(t)
+	cs := NewClientStore(db)
+	registered := clients.ReadClients("../../clients/clients_test.json")
+
+	assert.Nil(t, cs.Import(registered))
+	// importing again doesn't overwrite anything
+	assert.Nil(t, cs.Import(registered))
+
+	c, err := cs.Client("test_ac_grant")
+	if assert.Nil(t, err) {
+		assert.Equal(t, registered["test_ac_grant"].Name, c.Name)
+		assert.Equal(t, registered["test_ac_grant"].ClientSecrets, c.ClientSecrets)
+		assert.True(t, c.VerifySecret("71a0e768-0a57-4c2e-9d72-421d8bf3ca63"))
+		assert.Equal(t, registered["test_ac_grant"].RedirectUris, c.RedirectUris)
+		assert.Equal(t, registered["test_ac_grant"].AllowedScopes, c.AllowedScopes)
+		assert.Equal(t, []string{"https://api.example.com"}, c.AllowedResources)
+		assert.False(t, c.ResourceServer)
+	}
+	c, err = cs.Client("test_cc_grant")
+	if assert.Nil(t, err) {
+		assert.True(t, c.ResourceServer)
+	}
+	_, err = cs.Client("nope")
+	assert.ErrorIs(t, err, clients.ErrClientNotFound)
+}
+
+func TestClientStore_Import_RemovedSecret(t *testing.T) {
+	db, _ := openTestDB(t)
+	cs := NewClientStore(db)
+	registered := clients.ReadClients("../../clients/clients_test.json")
+	assert.Nil(t, cs.Import(registered))
+	assert.Nil(t, cs.AddSecret("test_cc_grant", clients.ClientSecret{Hash: clients.HashClientSecret("old secret")}))
+	_, err := db.Exec(`INSERT INTO clients (client_id, name, type, description, plaintext_secret, redirect_uris,
+		allowed_scopes, id_token_signed_response_alg) VALUES ('old', 'old', 'client_credentials', '', '', '[]', '[]', '')`)
+	assert.Nil(t, err)
+	assert.Nil(t, cs.AddSecret("old", clients.ClientSecret{Hash: clients.HashClientSecret("not in the file")}))
+
+	assert.Nil(t, cs.Import(registered))
+
+	c, err := cs.Client("test_cc_grant")
+	if assert.Nil(t, err) {
+		assert.False(t, c.VerifySecret("old secret"), "a secret removed from clients.json still works")
+		assert.True(t, c.VerifySecret("ddccd2d0-2b80-4ee8-ab33-beaa5fcb89fe"))
+	}
+	// clients that aren't in clients.json are left alone
+	var n int
+	assert.Nil(t, db.QueryRow(`SELECT COUNT(*) FROM client_secrets WHERE client_id = 'old'`).Scan(&n))
+	assert.Equal(t, 1, n)
+}
+
+func TestClientStore_AddSecret(t *testing.T) {
+	db, _ := openTestDB(t)
+	cs := NewClientStore(db)
+	assert.Nil(t, cs.Import(clients.ReadClients("../../clients/clients_test.json")))
+	rotated := clients.ClientSecret{Hash: clients.HashClientSecret("new secret")}
+
+	assert.Nil(t, cs.AddSecret("test_cc_grant", rotated))
+	expired := clients.ClientSecret{Hash: clients.HashClientSecret("ddccd2d0-2b80-4ee8-ab33-beaa5fcb89fe"), ExpiresAt: 1}
+	assert.Nil(t, cs.AddSecret("test_cc_grant", expired))
+
+	c, err := cs.Client("test_cc_grant")
+	assert.Nil(t, err)
+	assert.Len(t, c.ClientSecrets, 2)
+	assert.True(t, c.VerifySecret("new secret"))
+	assert.False(t, c.VerifySecret("ddccd2d0-2b80-4ee8-ab33-beaa5fcb89fe"))
+}
+
+func TestClientStore_CheckIdTokenAlgs(t *testing.T) {
+	db, _ := openTestDB(t)
+	cs := NewClientStore(db)
+	assert.Nil(t, cs.Import(clients.ReadClients("../../clients/clients_test.json")))
+
+	assert.Nil(t, cs.CheckIdTokenAlgs([]string{"RS256"}))
+
+	// a client imported before is checked too, even once it's gone from clients.json
+	_, err := db.Exec(`INSERT INTO clients (client_id, name, type, description, plaintext_secret, redirect_uris,
+		allowed_scopes, id_token_signed_response_alg) VALUES ('old', 'old', 'authorization_code', '', '', '[]', '[]', 'ES256')`)
+	assert.Nil(t, err)
+	assert.Error(t, cs.CheckIdTokenAlgs([]string{"RS256"}))
+	assert.Nil(t, cs.CheckIdTokenAlgs([]string{"RS256", "ES256"}))
+}
+
+func TestClientStore_HashPlaintextSecrets(t *testing.T) {
+	db, _ := openTestDB(t)
+	cs := NewClientStore(db)
+	_, err := db.Exec(`INSERT INTO clients (client_id, name, type, description, plaintext_secret, redirect_uris,
+		allowed_scopes, id_token_signed_response_alg) VALUES ('old', 'old', 'client_credentials', '', 'secret', '[]', '[]', '')`)
+	assert.Nil(t, err)
+
+	assert.Nil(t, cs.HashPlaintextSecrets())
+
+	c, err := cs.Client("old")
+	assert.Nil(t, err)
+	assert.True(t, c.VerifySecret("secret"))
+	var plaintext string
+	assert.Nil(t, db.QueryRow(`SELECT plaintext_secret FROM clients WHERE client_id = 'old'`).Scan(&plaintext))
+	assert.Empty(t, plaintext)
+}
+
+func TestUserStore_Import(t *testing.T) {
+	db, _ := openTestDB(t)
+	us := NewUserStore(db)
+
+	assert.Nil(t, us.Import(clients.Users{"jake": {Password: "password"}}))
+
+	u, err := us.User("jake")
+	assert.Nil(t, err)
+	assert.Empty(t, u.Password)
+	ok, _, err := clients.VerifyPassword(u.PasswordHash, "password")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	_, err = us.User("nope")
+	assert.ErrorIs(t, err, clients.ErrUserNotFound)
+}
+
+func TestPrune(t *testing.T) {
+	db, _ := openTestDB(t)
+	cs := NewCodeStore(db)
+	expired := auth.NewAuthorizationCode("", "plain", "")
+	expired.Exp = time.Now().Add(-time.Minute).Unix()
+	_ = cs.Add(expired)
+	live := auth.NewAuthorizationCode("", "plain", "")
+	_ = cs.Add(live)
+
+	assert.Nil(t, Prune(db))
+
+	_, err := cs.Redeem(expired.Code, "", "", "")
+	assert.ErrorIs(t, err, auth.ErrCodeNotFound)
+	_, err = cs.Redeem(live.Code, "", "", "")
+	assert.Nil(t, err)
+}
+
+func TestSessionStore(t *testing.T) {
+	db, _ := openTestDB(t)
+	ss := NewSessionStore(db)
+	created, err := ss.Create("jake")
+	assert.Nil(t, err)
+
+	var stored string
+	assert.Nil(t, db.QueryRow(`SELECT id_hash FROM sessions`).Scan(&stored))
+	assert.Equal(t, auth.HashToken(created.Id), stored)
+
+	s, err := ss.Get(created.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, "jake", s.Subject)
+	assert.Equal(t, created.AuthTime, s.AuthTime)
+	assert.Empty(t, s.Id)
+
+	assert.Nil(t, ss.Delete(created.Id))
+	_, err = ss.Get(created.Id)
+	assert.ErrorIs(t, err, auth.ErrSessionNotFound)
+}
+
+func TestSessionStore_IdleTimeout(t *testing.T) {
+	db, _ := openTestDB(t)
+	ss := NewSessionStore(db)
+	original := auth.SessionIdleTimeout
+	auth.SessionIdleTimeout = -time.Second
+	defer func() { auth.SessionIdleTimeout = original }()
+	idle, _ := ss.Create("jake")
+	auth.SessionIdleTimeout = original
+	live, _ := ss.Create("jake")
+
+	assert.Nil(t, Prune(db))
+
+	var count int
+	assert.Nil(t, db.QueryRow(`SELECT COUNT(*) FROM sessions`).Scan(&count))
+	assert.Equal(t, 1, count)
+	_, err := ss.Get(idle.Id)
+	assert.ErrorIs(t, err, auth.ErrSessionNotFound)
+	_, err = ss.Get(live.Id)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,108 @@
+package sqlite
+
+import (
+	"JakeOAuth/auth"
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// TokenStore is a storage.TokenStore kept in the refresh_tokens table, tokens are stored by their hash
+type TokenStore struct {
+	db *sql.DB
+}
+
+func NewTokenStore(db *sql.DB) *TokenStore {
+	return &TokenStore{db: db}
+}
+
+const refreshTokenColumns = `id, exp, issued_at, token_hash, client_id, subject, scope, family_id, used`
+
+// querier is what *sql.DB and *sql.Tx have in common
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func (ts *TokenStore) Issue(familyId, clientId, subject, scope string) (*auth.RefreshToken, error) {
+	if familyId == "" {
+		familyId = uuid.NewString()
+	}
+	return issue(ts.db, familyId, clientId, subject, scope)
+}
+
+func issue(q querier, familyId, clientId, subject, scope string) (*auth.RefreshToken, error) {
+	rt, err := auth.NewRefreshToken(familyId, clientId, subject, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = q.Exec(`INSERT INTO refresh_tokens (`+refreshTokenColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		rt.Id, rt.Exp, rt.IssuedAt, rt.TokenHash, rt.ClientId, rt.Subject, rt.Scope, rt.FamilyId, rt.Used)
+	if err != nil {
+		return nil, err
+	}
+	return rt, nil
+}
+
+func (ts *TokenStore) Peek(token string) (auth.RefreshToken, error) {
+	rt, err := selectRefreshToken(ts.db, token)
+	if err != nil {
+		return auth.RefreshToken{}, err
+	}
+	return *rt, nil
+}
+
+func selectRefreshToken(q querier, token string) (*auth.RefreshToken, error) {
+	rt := &auth.RefreshToken{}
+	err := q.QueryRow(`SELECT `+refreshTokenColumns+` FROM refresh_tokens WHERE token_hash = ?`, auth.HashToken(token)).
+		Scan(&rt.Id, &rt.Exp, &rt.IssuedAt, &rt.TokenHash, &rt.ClientId, &rt.Subject, &rt.Scope, &rt.FamilyId, &rt.Used)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, auth.ErrRefreshTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rt, nil
+}
+
+// Rotate works like auth.RefreshTokenStore.Rotate, all in one transaction
+func (ts *TokenStore) Rotate(token, clientId string) (*auth.RefreshToken, error) {
+	tx, err := ts.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	rt, err := selectRefreshToken(tx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if revokeFamily, err := rt.CheckRotate(clientId); err != nil {
+		if revokeFamily {
+			if _, revokeErr := tx.Exec(`DELETE FROM refresh_tokens WHERE family_id = ?`, rt.FamilyId); revokeErr != nil {
+				return nil, revokeErr
+			}
+			if commitErr := tx.Commit(); commitErr != nil {
+				return nil, commitErr
+			}
+		}
+		return nil, err
+	}
+
+	if _, err = tx.Exec(`UPDATE refresh_tokens SET used = 1 WHERE token_hash = ?`, rt.TokenHash); err != nil {
+		return nil, err
+	}
+	rotated, err := issue(tx, rt.FamilyId, rt.ClientId, rt.Subject, rt.Scope)
+	if err != nil {
+		return nil, err
+	}
+	return rotated, tx.Commit()
+}
+
+func (ts *TokenStore) RevokeFamily(familyId string) error {
+	_, err := ts.db.Exec(`DELETE FROM refresh_tokens WHERE family_id = ?`, familyId)
+	return err
+}
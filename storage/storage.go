@@ -0,0 +1,59 @@
+// Package storage has the interfaces the handlers use to keep codes, tokens, clients, users and sessions.
+// The in-memory backend is auth.AuthorizationCodeStore, auth.RefreshTokenStore, clients.Clients,
+// clients.UserFile and auth.SessionStore, storage/sqlite keeps everything in a SQLite database so it
+// survives a restart
+package storage
+
+import (
+	"JakeOAuth/auth"
+	"JakeOAuth/clients"
+)
+
+// CodeStore keeps authorization codes until they are redeemed or expire
+type CodeStore interface {
+	Add(code *auth.AuthorizationCode) error
+	// Redeem returns the code along with auth.ErrCodeReplayed if it was already redeemed,
+	// and auth.ErrCodeNotFound if it doesn't exist
+	Redeem(authCode, pkceCode, clientId, redirectUri string) (*auth.AuthorizationCode, error)
+}
+
+// TokenStore keeps refresh tokens and the family each one was rotated in
+type TokenStore interface {
+	Issue(familyId, clientId, subject, scope string) (*auth.RefreshToken, error)
+	// Peek returns auth.ErrRefreshTokenNotFound if the token doesn't exist
+	Peek(token string) (auth.RefreshToken, error)
+	Rotate(token, clientId string) (*auth.RefreshToken, error)
+	RevokeFamily(familyId string) error
+}
+
+// ClientStore looks up registered clients
+type ClientStore interface {
+	// Client returns clients.ErrClientNotFound if the client isn't registered
+	Client(clientId string) (clients.Client, error)
+}
+
+// UserStore looks up users that can log in
+type UserStore interface {
+	// User returns clients.ErrUserNotFound if there is no user with the username
+	User(username string) (clients.User, error)
+	// SetPasswordHash is used to upgrade the user's password hash when they log in
+	SetPasswordHash(username, hash string) error
+}
+
+// SessionStore keeps the sessions of logged-in users
+type SessionStore interface {
+	// Create returns the new session with its Id set, it's only ever sent in the session cookie
+	Create(subject string) (*auth.Session, error)
+	// Get returns auth.ErrSessionNotFound if the session doesn't exist or has timed out, otherwise its
+	// idle timeout starts over
+	Get(id string) (auth.Session, error)
+	Delete(id string) error
+}
+
+var (
+	_ CodeStore    = (*auth.AuthorizationCodeStore)(nil)
+	_ TokenStore   = (*auth.RefreshTokenStore)(nil)
+	_ ClientStore  = clients.Clients(nil)
+	_ UserStore    = (*clients.UserFile)(nil)
+	_ SessionStore = (*auth.SessionStore)(nil)
+)